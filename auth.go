@@ -0,0 +1,504 @@
+package zhttp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Authenticator add the credentials to the requests.
+// It is called for every round trip, including redirects to the same domain or subdomains and retries,
+// so the request is signed again with the final url and body
+type Authenticator interface {
+	// Authenticate add the credentials to req before it is sent,
+	// the body of req can be got by req.GetBody if it is not nil
+	Authenticate(req *http.Request) error
+}
+
+// ChallengeAuthenticator is an Authenticator which answers the challenge of server.
+// When the response is 401, Challenge is called with resp and a copy of the request,
+// the copy is sent again if Challenge returns true
+type ChallengeAuthenticator interface {
+	Authenticator
+
+	// Challenge add the credentials computed from the challenge in resp to req
+	Challenge(req *http.Request, resp *http.Response) (bool, error)
+}
+
+// maxAuthRounds is the max number of challenges answered for a round trip
+const maxAuthRounds = 3
+
+var ctxAuthKey = &struct{ name string }{"authenticator"}
+
+// authenticator return the authenticator of the request in the following order:
+// ReqOptions.Authenticator, Session.Authenticator and HTTPOptions.Authenticator
+func (z *Zhttp) authenticator(options *ReqOptions, s *Session) Authenticator {
+	if options.Authenticator != nil {
+		return options.Authenticator
+	}
+	if s != nil && s.Authenticator != nil {
+		return s.Authenticator
+	}
+	return z.options.Authenticator
+}
+
+// roundTripAuth send the request by send with the credentials added by auth,
+// and answer the challenges if auth is a ChallengeAuthenticator
+func roundTripAuth(req *http.Request, auth Authenticator, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	// the RoundTripper should not modify the request
+	req = req.Clone(req.Context())
+
+	err := auth.Authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(req)
+
+	ca, ok := auth.(ChallengeAuthenticator)
+	if !ok {
+		return resp, err
+	}
+
+	for i := 0; i < maxAuthRounds && err == nil && resp.StatusCode == http.StatusUnauthorized; i++ {
+		next := req.Clone(req.Context())
+		if req.Body != nil && req.Body != http.NoBody {
+			// the body can not be sent again
+			if req.GetBody == nil {
+				break
+			}
+			next.Body, err = req.GetBody()
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+
+		var retry bool
+		retry, err = ca.Challenge(next, resp)
+		if err != nil || !retry {
+			if next.Body != nil {
+				next.Body.Close()
+			}
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			break
+		}
+
+		// drain the body, so that the connection can be reused, which is required by NTLM
+		io.CopyN(ioutil.Discard, resp.Body, 4096)
+		resp.Body.Close()
+
+		req = next
+		resp, err = send(req)
+	}
+
+	return resp, err
+}
+
+// authAllowed report whether the credentials can be sent with req.
+// Like the sensitive headers of go, the credentials are not sent if the request is redirected to another domain
+func authAllowed(req *http.Request) bool {
+	first := req
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+
+	if first == req {
+		return true
+	}
+
+	return isSameOrSubdomain(req.URL.Hostname(), first.URL.Hostname())
+}
+
+func isSameOrSubdomain(sub, parent string) bool {
+	sub = strings.ToLower(sub)
+	parent = strings.ToLower(parent)
+	return sub == parent || strings.HasSuffix(sub, "."+parent)
+}
+
+// readBody return the content of the request body by GetBody.
+// ok is false if the body exists but can not be got again
+func readBody(req *http.Request) (data []byte, ok bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.GetBody == nil {
+		return nil, false, nil
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	data, err = ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+// Authenticate make Auth an Authenticator which performs HTTP Basic authentication
+func (a Auth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// Basic create an Authenticator which performs HTTP Basic authentication
+func Basic(username, password string) Authenticator {
+	return Auth{Username: username, Password: password}
+}
+
+// Bearer create an Authenticator which sends the bearer token
+func Bearer(token string) Authenticator {
+	return &BearerAuth{Token: token}
+}
+
+type BearerAuth struct {
+	Token string
+}
+
+func (a *BearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Digest create an Authenticator which performs HTTP Digest authentication of RFC 7616
+func Digest(username, password string) Authenticator {
+	return &DigestAuth{Username: username, Password: password}
+}
+
+// DigestAuth performs HTTP Digest authentication of RFC 7616.
+// The algorithms MD5, SHA-256, SHA-512-256 and their -sess variants are supported,
+// qop auth-int is used only when auth is not offered by server.
+// The challenge of each host is cached, and the following requests to the host are authenticated without a 401 response
+type DigestAuth struct {
+	Username string
+	Password string
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	userhash  bool
+	nc        uint32
+}
+
+func (a *DigestAuth) Authenticate(req *http.Request) error {
+	a.mu.Lock()
+	c, ok := a.challenges[req.URL.Host]
+	var nc uint32
+	if ok {
+		c.nc++
+		nc = c.nc
+	}
+	a.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return a.authorize(req, c, nc)
+}
+
+func (a *DigestAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	c := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if c == nil {
+		return false, nil
+	}
+
+	// the credentials are rejected if the nonce is not stale
+	stale := strings.EqualFold(c.params["stale"], "true")
+	if !stale && strings.Contains(resp.Request.Header.Get("Authorization"), `nonce="`+c.nonce+`"`) {
+		return false, nil
+	}
+
+	c.nc = 1
+
+	a.mu.Lock()
+	if a.challenges == nil {
+		a.challenges = map[string]*digestChallenge{}
+	}
+	a.challenges[req.URL.Host] = &c.digestChallenge
+	a.mu.Unlock()
+
+	err := a.authorize(req, &c.digestChallenge, 1)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// authorize set the Authorization header with the challenge
+func (a *DigestAuth) authorize(req *http.Request, c *digestChallenge, nc uint32) error {
+	algorithm := strings.ToUpper(c.algorithm)
+	sess := strings.HasSuffix(algorithm, "-SESS")
+	newHash := digestHash(strings.TrimSuffix(algorithm, "-SESS"))
+	if newHash == nil {
+		return fmt.Errorf("zhttp: unsupported digest algorithm %q", c.algorithm)
+	}
+
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	cnonce, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	ncValue := fmt.Sprintf("%08x", nc)
+	uri := req.URL.RequestURI()
+
+	ha1 := h(a.Username + ":" + c.realm + ":" + a.Password)
+	if sess {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+
+	qop := c.qop
+	ha2 := h(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		body, ok, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("zhttp: digest auth-int requires the body which can be read again")
+		}
+		ha2 = h(req.Method + ":" + uri + ":" + h(string(body)))
+	}
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + ncValue + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	username := a.Username
+	if c.userhash {
+		username = h(a.Username + ":" + c.realm)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, response="%s"`,
+		quoteParam(username), quoteParam(c.realm), quoteParam(c.nonce), quoteParam(uri), response)
+	if c.algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", c.algorithm)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%s", quoteParam(c.opaque))
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s"`, qop, ncValue, cnonce)
+	}
+	if c.userhash {
+		b.WriteString(", userhash=true")
+	}
+
+	req.Header.Set("Authorization", b.String())
+
+	return nil
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+	return nil
+}
+
+// digestStrength is used to choose the strongest algorithm when server offers multiple challenges
+func digestStrength(algorithm string) int {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return 1
+	case "SHA-256":
+		return 2
+	case "SHA-512-256":
+		return 3
+	}
+	return 0
+}
+
+type parsedDigestChallenge struct {
+	digestChallenge
+	params map[string]string
+}
+
+// parseDigestChallenge parse the Digest challenges in WWW-Authenticate headers,
+// and return the one with the strongest supported algorithm
+func parseDigestChallenge(headers []string) *parsedDigestChallenge {
+	var best *parsedDigestChallenge
+
+	for _, header := range headers {
+		for _, challenge := range parseAuthChallenges(header) {
+			if !strings.EqualFold(challenge.scheme, "Digest") {
+				continue
+			}
+
+			p := challenge.params
+			c := &parsedDigestChallenge{
+				digestChallenge: digestChallenge{
+					realm:     p["realm"],
+					nonce:     p["nonce"],
+					opaque:    p["opaque"],
+					algorithm: p["algorithm"],
+					userhash:  strings.EqualFold(p["userhash"], "true"),
+				},
+				params: p,
+			}
+
+			if c.nonce == "" || digestStrength(c.algorithm) == 0 {
+				continue
+			}
+
+			if qop, ok := p["qop"]; ok {
+				for _, q := range strings.Split(qop, ",") {
+					q = strings.TrimSpace(q)
+					if q == "auth" {
+						c.qop = q
+						break
+					}
+					if q == "auth-int" {
+						c.qop = q
+					}
+				}
+				if c.qop == "" {
+					continue
+				}
+			}
+
+			if best == nil || digestStrength(c.algorithm) > digestStrength(best.algorithm) {
+				best = c
+			}
+		}
+	}
+
+	return best
+}
+
+type authChallenge struct {
+	scheme string
+	token  string
+	params map[string]string
+}
+
+// parseAuthChallenges parse the challenges in a WWW-Authenticate header, such as
+// `Digest realm="a", nonce="b", NTLM, Negotiate abc=`
+func parseAuthChallenges(header string) []*authChallenge {
+	var list []*authChallenge
+	var current *authChallenge
+
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+
+		// read a token
+		i := strings.IndexAny(s, " \t,=")
+		if i < 0 {
+			i = len(s)
+		}
+		token := s[:i]
+		s = s[i:]
+
+		rest := strings.TrimLeft(s, " \t")
+		if current != nil && strings.HasPrefix(rest, "=") {
+			// auth-param
+			value, remain := readParamValue(strings.TrimLeft(rest[1:], " \t"))
+			current.params[strings.ToLower(token)] = value
+			s = remain
+			continue
+		}
+
+		// a new challenge, which may be followed by a token68
+		current = &authChallenge{scheme: token, params: map[string]string{}}
+		list = append(list, current)
+
+		rest = strings.TrimLeft(s, " \t")
+		if rest != "" && rest[0] != ',' {
+			j := strings.IndexAny(rest, " \t,")
+			if j < 0 {
+				j = len(rest)
+			}
+			word := rest[:j]
+			if !strings.Contains(strings.TrimRight(word, "="), "=") {
+				current.token = word
+				s = rest[j:]
+			}
+		}
+	}
+
+	return list
+}
+
+// readParamValue read a token or a quoted string
+func readParamValue(s string) (value, remain string) {
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					b.WriteByte(s[i])
+				}
+			case '"':
+				return b.String(), s[i+1:]
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return b.String(), ""
+	}
+
+	i := strings.IndexAny(s, " \t,")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
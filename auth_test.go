@@ -0,0 +1,163 @@
+package zhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []string
+		nonce     string
+		algorithm string
+		qop       string
+		opaque    string
+		userhash  bool
+	}{
+		{
+			name:    "rfc 2069 without qop",
+			headers: []string{`Digest realm="test", nonce="abc"`},
+			nonce:   "abc",
+		},
+		{
+			name:      "auth is preferred over auth-int",
+			headers:   []string{`Digest realm="test", nonce="abc", qop="auth-int, auth", algorithm=MD5`},
+			nonce:     "abc",
+			algorithm: "MD5",
+			qop:       "auth",
+		},
+		{
+			name:    "auth-int only",
+			headers: []string{`Digest realm="test", nonce="abc", qop="auth-int"`},
+			nonce:   "abc",
+			qop:     "auth-int",
+		},
+		{
+			name:    "unsupported qop",
+			headers: []string{`Digest realm="test", nonce="abc", qop="auth-conf"`},
+		},
+		{
+			name:    "unsupported algorithm",
+			headers: []string{`Digest realm="test", nonce="abc", algorithm=SHA-1`},
+		},
+		{
+			name:    "missing nonce",
+			headers: []string{`Digest realm="test", qop="auth"`},
+		},
+		{
+			name:    "basic only",
+			headers: []string{`Basic realm="test"`},
+		},
+		{
+			name:      "strongest algorithm in one header",
+			headers:   []string{`Digest realm="test", nonce="a", qop="auth", algorithm=MD5, Digest realm="test", nonce="b", qop="auth", algorithm=SHA-256`},
+			nonce:     "b",
+			algorithm: "SHA-256",
+			qop:       "auth",
+		},
+		{
+			name: "strongest algorithm in multiple headers",
+			headers: []string{
+				`Basic realm="test"`,
+				`Digest realm="test", nonce="a", qop="auth", algorithm=SHA-512-256-sess`,
+				`Digest realm="test", nonce="b", qop="auth", algorithm=SHA-256`,
+			},
+			nonce:     "a",
+			algorithm: "SHA-512-256-sess",
+			qop:       "auth",
+		},
+		{
+			name:      "opaque and userhash",
+			headers:   []string{`Digest realm="test", nonce="abc", opaque="x\"y", userhash=true, qop=auth, algorithm=SHA-256`},
+			nonce:     "abc",
+			algorithm: "SHA-256",
+			qop:       "auth",
+			opaque:    `x"y`,
+			userhash:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parseDigestChallenge(tt.headers)
+			if tt.nonce == "" {
+				if c != nil {
+					t.Fatalf("got challenge %+v, want nil", c.digestChallenge)
+				}
+				return
+			}
+			if c == nil {
+				t.Fatal("got nil challenge")
+			}
+			if c.realm != "test" || c.nonce != tt.nonce || c.algorithm != tt.algorithm || c.qop != tt.qop ||
+				c.opaque != tt.opaque || c.userhash != tt.userhash {
+				t.Errorf("got challenge %+v", c.digestChallenge)
+			}
+		})
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth", algorithm=SHA-256`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		for _, param := range []string{`username="user"`, `nonce="abc"`, `uri="/path?a=1"`, "qop=auth", "nc=00000001", "algorithm=SHA-256"} {
+			if !strings.Contains(auth, param) {
+				t.Errorf("Authorization %q does not contain %s", auth, param)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	z := New(&HTTPOptions{Authenticator: Digest("user", "pass")})
+	resp, err := z.Get(ts.URL+"/path?a=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRoundTripAuthChallengeError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// fail the request answering the challenge
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	z := New(&HTTPOptions{Authenticator: Digest("user", "pass")})
+	resp, err := z.Get(ts.URL, nil)
+	if err == nil {
+		resp.Close()
+		t.Fatalf("got status %d, want error", resp.StatusCode)
+	}
+	if resp != nil {
+		t.Errorf("got response with error %v", err)
+	}
+	if atomic.LoadInt32(&requests) < 2 {
+		t.Errorf("the challenge is not answered")
+	}
+}
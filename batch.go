@@ -0,0 +1,112 @@
+package zhttp
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the number of workers used when the concurrency of batch is not positive
+const DefaultBatchConcurrency = 10
+
+// Job is a request to be sent by Batch
+type Job struct {
+	Method  string
+	URL     string
+	Options *ReqOptions
+
+	// Session is used to send the request if it is not nil
+	Session *Session
+
+	// Data is attached to the job by caller, it is not used by zhttp
+	Data interface{}
+}
+
+// Result is the result of a job, either Response or Err is set
+type Result struct {
+	Job      *Job
+	Response *Response
+	Err      error
+}
+
+// Batch send the jobs with at most concurrency requests at the same time,
+// and call fn with each result in completion order. fn is never called concurrently.
+// The response is drained and closed after fn returns, so it must not be used after that.
+// Batch returns after all jobs are finished, or ctx is done and the running requests are finished,
+// the error of ctx is returned in the latter case.
+// The jobs channel should be closed by caller after all jobs are sent
+func (z *Zhttp) Batch(ctx context.Context, jobs <-chan *Job, concurrency int, fn func(*Result)) error {
+	for result := range z.BatchChan(ctx, jobs, concurrency) {
+		if fn != nil {
+			fn(result)
+		}
+		if result.Response != nil {
+			result.Response.discard()
+		}
+	}
+
+	return ctx.Err()
+}
+
+// BatchChan is like Batch, but return the results by a channel in completion order.
+// The channel is closed after all jobs are finished, or ctx is done and the running requests are finished.
+// The response of each result must be closed by caller.
+// The responses which can not be delivered after ctx is done are closed by BatchChan
+func (z *Zhttp) BatchChan(ctx context.Context, jobs <-chan *Job, concurrency int) <-chan *Result {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	results := make(chan *Result)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			z.batchWorker(ctx, jobs, results)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+func (z *Zhttp) batchWorker(ctx context.Context, jobs <-chan *Job, results chan<- *Result) {
+	for {
+		var job *Job
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok = <-jobs:
+			if !ok {
+				return
+			}
+		}
+
+		if job == nil {
+			continue
+		}
+
+		result := &Result{Job: job}
+		if job.Session != nil {
+			result.Response, result.Err = job.Session.RequestContext(ctx, job.Method, job.URL, job.Options)
+		} else {
+			result.Response, result.Err = z.RequestContext(ctx, job.Method, job.URL, job.Options)
+		}
+
+		select {
+		case results <- result:
+		case <-ctx.Done():
+			if result.Response != nil {
+				result.Response.Close()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,813 @@
+package zhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ParsedRequest is a request parsed from a curl command or a raw http request,
+// it can be sent by Zhttp.Request(p.Method, p.URL, p.Options)
+type ParsedRequest struct {
+	Method  string
+	URL     string
+	Options *ReqOptions
+
+	// InsecureSkipVerify is true when the curl command disables certificate verification by -k,
+	// Options.InsecureSkipVerify is also set
+	InsecureSkipVerify bool
+}
+
+// curl options which take an argument but are not supported, they are skipped with the argument
+var curlIgnoredArgOptions = map[string]bool{
+	"-o": true, "--output": true, "-w": true, "--write-out": true,
+	"-c": true, "--cookie-jar": true, "--connect-timeout": true,
+	"--retry": true, "--retry-delay": true, "--retry-max-time": true,
+	"-E": true, "--cert": true, "--key": true, "--cacert": true, "--capath": true,
+	"--cert-type": true, "--key-type": true, "--ciphers": true, "-r": true, "--range": true,
+	"-T": true, "--upload-file": true, "--limit-rate": true, "-K": true, "--config": true,
+	"--max-redirs": true, "--interface": true, "--dns-servers": true, "--proxy-header": true,
+	"-D": true, "--dump-header": true, "--trace": true, "--trace-ascii": true,
+}
+
+// curl options which take an argument and are supported
+var curlArgOptions = map[string]bool{
+	"-X": true, "--request": true, "-H": true, "--header": true,
+	"-d": true, "--data": true, "--data-ascii": true, "--data-raw": true,
+	"--data-binary": true, "--data-urlencode": true, "-F": true, "--form": true,
+	"--form-string": true, "-b": true, "--cookie": true, "-u": true, "--user": true,
+	"-x": true, "--proxy": true, "-U": true, "--proxy-user": true, "--resolve": true,
+	"-A": true, "--user-agent": true, "-e": true, "--referer": true, "--url": true,
+	"-m": true, "--max-time": true,
+}
+
+// ParseCurl parse a curl command line, such as the one copied by "Copy as cURL" of browsers,
+// into method, url and ReqOptions. The following options are supported:
+// -X, -H, -d, --data-raw, --data-binary, --data-urlencode, -F, --form-string, -b, -u,
+// -x, -U, -k, --resolve, -A, -e, -G, -I, -L, -m, --url.
+// Unsupported options are ignored. Redirects are disabled unless -L is set, like curl does
+func ParseCurl(command string) (*ParsedRequest, error) {
+	args, err := splitCommandLine(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 || !isCurlProgram(args[0]) {
+		return nil, errors.New("zhttp: not a curl command")
+	}
+
+	p := &curlParser{
+		options: &ReqOptions{DisableRedirect: true},
+	}
+
+	err = p.parse(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return p.result()
+}
+
+// isCurlProgram report whether name is curl, such as /usr/bin/curl and curl.exe
+func isCurlProgram(name string) bool {
+	// the path may be a windows path, which is not split by filepath on other systems
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	return name == "curl" || strings.EqualFold(name, "curl.exe")
+}
+
+type curlParser struct {
+	method    string
+	rawURL    string
+	options   *ReqOptions
+	data      []string
+	files     []*File
+	form      map[string]string
+	get       bool
+	head      bool
+	insecure  bool
+	proxy     string
+	proxyUser string
+	resolve   []string
+}
+
+func (p *curlParser) parse(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			p.rawURL = arg
+			continue
+		}
+
+		name, value, hasValue := arg, "", false
+		if !strings.HasPrefix(arg, "--") && len(arg) > 2 {
+			// short options may be combined, like -sSk, and the option with argument ends the combination,
+			// its value is the rest of arg or the next arg, like -XPOST, -sXPOST and -sX POST
+			name = ""
+			for j := 1; j < len(arg); j++ {
+				opt := "-" + arg[j:j+1]
+				if !curlArgOptions[opt] && !curlIgnoredArgOptions[opt] {
+					p.flag(opt)
+					continue
+				}
+				name = opt
+				if j+1 < len(arg) {
+					value, hasValue = arg[j+1:], true
+				}
+				break
+			}
+			if name == "" {
+				continue
+			}
+		}
+
+		if !curlArgOptions[name] && !curlIgnoredArgOptions[name] {
+			p.flag(name)
+			continue
+		}
+
+		if !hasValue {
+			i++
+			if i >= len(args) {
+				return fmt.Errorf("zhttp: curl option %s requires an argument", name)
+			}
+			value = args[i]
+		}
+
+		if curlIgnoredArgOptions[name] {
+			continue
+		}
+
+		err := p.option(name, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.rawURL == "" {
+		return errors.New("zhttp: no url in curl command")
+	}
+
+	return nil
+}
+
+// flag handle the options without argument
+func (p *curlParser) flag(name string) {
+	switch name {
+	case "-k", "--insecure":
+		p.insecure = true
+		p.options.InsecureSkipVerify = true
+	case "-G", "--get":
+		p.get = true
+	case "-I", "--head":
+		p.head = true
+	case "-L", "--location":
+		p.options.DisableRedirect = false
+	}
+}
+
+func (p *curlParser) option(name, value string) error {
+	switch name {
+	case "-X", "--request":
+		p.method = value
+	case "-H", "--header":
+		p.header(value)
+	case "-d", "--data", "--data-ascii":
+		if strings.HasPrefix(value, "@") {
+			data, err := ioutil.ReadFile(value[1:])
+			if err != nil {
+				return err
+			}
+			value = strings.NewReplacer("\r", "", "\n", "").Replace(string(data))
+		}
+		p.data = append(p.data, value)
+	case "--data-raw":
+		p.data = append(p.data, value)
+	case "--data-binary":
+		if strings.HasPrefix(value, "@") {
+			data, err := ioutil.ReadFile(value[1:])
+			if err != nil {
+				return err
+			}
+			value = string(data)
+		}
+		p.data = append(p.data, value)
+	case "--data-urlencode":
+		data, err := curlURLEncode(value)
+		if err != nil {
+			return err
+		}
+		p.data = append(p.data, data)
+	case "-F", "--form":
+		return p.formField(value, false)
+	case "--form-string":
+		return p.formField(value, true)
+	case "-b", "--cookie":
+		// a value without '=' is a cookie file
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("zhttp: cookie file %q of curl option %s is not supported", value, name)
+		}
+		if p.options.Cookies == nil {
+			p.options.Cookies = map[string]string{}
+		}
+		for k, v := range CookieMapFromRaw(value) {
+			p.options.Cookies[k] = v
+		}
+	case "-u", "--user":
+		username, password, _ := strings.Cut(value, ":")
+		p.options.Auth = Auth{Username: username, Password: password}
+	case "-x", "--proxy":
+		p.proxy = value
+	case "-U", "--proxy-user":
+		p.proxyUser = value
+	case "--resolve":
+		p.resolve = append(p.resolve, value)
+	case "-A", "--user-agent":
+		p.options.UserAgent = value
+	case "-e", "--referer":
+		applyHeader(p.options, "Referer", value)
+	case "--url":
+		p.rawURL = value
+	case "-m", "--max-time":
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("zhttp: invalid curl max time %q", value)
+		}
+		p.options.RequestTimeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	return nil
+}
+
+// header handle the header of -H, the special headers are mapped to the fields of ReqOptions
+func (p *curlParser) header(line string) {
+	name, value, ok := strings.Cut(line, ":")
+	if !ok {
+		// "Name;" means a header with empty value
+		if strings.HasSuffix(line, ";") {
+			applyHeader(p.options, strings.TrimSuffix(line, ";"), "")
+		}
+		return
+	}
+
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if value == "" {
+		// "Name:" means removing the header
+		return
+	}
+
+	applyHeader(p.options, name, value)
+}
+
+// applyHeader set the header to ReqOptions, the special headers are mapped to the fields of ReqOptions
+func applyHeader(options *ReqOptions, name, value string) {
+	switch strings.ToLower(name) {
+	case "cookie":
+		if options.Cookies == nil {
+			options.Cookies = map[string]string{}
+		}
+		for k, v := range CookieMapFromRaw(value) {
+			options.Cookies[k] = v
+		}
+	case "user-agent":
+		options.UserAgent = value
+	case "content-type":
+		options.ContentType = value
+	case "host":
+		options.Host = value
+	case "content-length":
+		// it is calculated from the body
+	default:
+		if options.Headers == nil {
+			options.Headers = map[string]string{}
+		}
+		if old, ok := options.Headers[name]; ok {
+			options.Headers[name] = old + ", " + value
+		} else {
+			options.Headers[name] = value
+		}
+	}
+}
+
+// formField handle -F name=value, name=@file;type=mime;filename=name and name=<file
+func (p *curlParser) formField(field string, literal bool) error {
+	name, value, ok := strings.Cut(field, "=")
+	if !ok {
+		return fmt.Errorf("zhttp: invalid curl form field %q", field)
+	}
+
+	if p.form == nil {
+		p.form = map[string]string{}
+	}
+
+	if literal || (!strings.HasPrefix(value, "@") && !strings.HasPrefix(value, "<")) {
+		p.form[name] = value
+		return nil
+	}
+
+	params := strings.Split(value[1:], ";")
+	path := params[0]
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if value[0] == '<' {
+		p.form[name] = string(data)
+		return nil
+	}
+
+	file := &File{
+		Name:      filepath.Base(path),
+		Contents:  ioutil.NopCloser(bytes.NewReader(data)),
+		FieldName: name,
+		Mime:      mime.TypeByExtension(filepath.Ext(path)),
+	}
+	for _, param := range params[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		switch strings.TrimSpace(k) {
+		case "type":
+			file.Mime = v
+		case "filename":
+			file.Name = strings.Trim(v, `"`)
+		}
+	}
+
+	p.files = append(p.files, file)
+
+	return nil
+}
+
+func (p *curlParser) result() (*ParsedRequest, error) {
+	rawURL := p.rawURL
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	method := p.method
+	switch {
+	case p.head:
+		method = "HEAD"
+	case p.get && len(p.data) > 0:
+		query := strings.Join(p.data, "&")
+		if u.RawQuery != "" {
+			u.RawQuery += "&" + query
+		} else {
+			u.RawQuery = query
+		}
+		if method == "" {
+			method = "GET"
+		}
+	case len(p.data) > 0:
+		p.options.Body = String(strings.Join(p.data, "&"))
+		if p.options.ContentType == "" {
+			p.options.ContentType = "application/x-www-form-urlencoded"
+		}
+		if method == "" {
+			method = "POST"
+		}
+	case len(p.files) > 0 || len(p.form) > 0:
+		p.options.Body = Multipart(p.files, p.form)
+		if method == "" {
+			method = "POST"
+		}
+	}
+	if method == "" {
+		method = "GET"
+	}
+
+	if p.proxy != "" {
+		proxy := p.proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		if p.proxyUser != "" {
+			username, password, _ := strings.Cut(p.proxyUser, ":")
+			proxyURL.User = url.UserPassword(username, password)
+		}
+		p.options.Proxies = map[string]*url.URL{
+			"http":  proxyURL,
+			"https": proxyURL,
+		}
+	}
+
+	// --resolve host:port:addr
+	for _, item := range p.resolve {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		if strings.EqualFold(parts[0], u.Hostname()) && (parts[1] == port || parts[1] == "*") {
+			p.options.HostIP = strings.Trim(parts[2], "[]")
+		}
+	}
+
+	return &ParsedRequest{
+		Method:             method,
+		URL:                u.String(),
+		Options:            p.options,
+		InsecureSkipVerify: p.insecure,
+	}, nil
+}
+
+// curlURLEncode handle the value of --data-urlencode
+func curlURLEncode(value string) (string, error) {
+	if i := strings.IndexAny(value, "=@"); i >= 0 {
+		name, content := value[:i], value[i+1:]
+		if value[i] == '@' {
+			data, err := ioutil.ReadFile(content)
+			if err != nil {
+				return "", err
+			}
+			content = string(data)
+		}
+		if name == "" {
+			return url.QueryEscape(content), nil
+		}
+		return name + "=" + url.QueryEscape(content), nil
+	}
+
+	return url.QueryEscape(value), nil
+}
+
+// splitCommandLine split a shell command line into arguments, it supports
+// single quotes, double quotes, $'...' strings, backslash escapes and line continuations
+func splitCommandLine(command string) ([]string, error) {
+	var args []string
+	var buf strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+
+		switch {
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				// line continuation
+				if command[i] == '\n' {
+					continue
+				}
+				if command[i] == '\r' && i+1 < len(command) && command[i+1] == '\n' {
+					i++
+					continue
+				}
+				buf.WriteByte(command[i])
+				inArg = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("zhttp: unterminated single quote")
+			}
+			buf.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '$' && i+1 < len(command) && command[i+1] == '\'':
+			n, err := readANSIQuoted(command[i+2:], &buf)
+			if err != nil {
+				return nil, err
+			}
+			i += n + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\\\"$`\n", command[i+1]) >= 0 {
+					i++
+					if command[i] == '\n' {
+						continue
+					}
+				}
+				buf.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, errors.New("zhttp: unterminated double quote")
+			}
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+		default:
+			buf.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, buf.String())
+	}
+
+	return args, nil
+}
+
+// readANSIQuoted read the content of $'...' until the closing quote,
+// return the number of bytes consumed including the closing quote
+func readANSIQuoted(s string, buf *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(s) {
+			buf.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case '0':
+			buf.WriteByte(0)
+		case 'x':
+			if i+2 < len(s) {
+				b, err := hex.DecodeString(s[i+1 : i+3])
+				if err == nil {
+					buf.Write(b)
+					i += 2
+					continue
+				}
+			}
+			buf.WriteString(`\x`)
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return 0, errors.New("zhttp: unterminated $' quote")
+}
+
+// DumpCurl format the request which would be sent by Zhttp.Request to a curl command line.
+// Besides the headers, cookies and body, the transport settings like proxy, HostIP and
+// InsecureSkipVerify are included. The Body which can not be reused, such as ReaderBody, will be consumed
+func (z *Zhttp) DumpCurl(method, rawURL string, options *ReqOptions) (string, error) {
+	if options == nil {
+		options = &ReqOptions{}
+	}
+
+	req, body, err := z.buildDumpRequest(method, rawURL, options)
+	if err != nil {
+		return "", err
+	}
+
+	return z.formatCurl(req, body, false, z.proxyForDump(req, options), options), nil
+}
+
+// DumpCurl format the last request of the response to a curl command line.
+// Besides the headers, cookies and body, the transport settings like proxy, HostIP and
+// InsecureSkipVerify are included, the proxy is the one actually used by the request.
+// The Body which can not be reused, such as ReaderBody and MultipartStream, has been consumed by sending,
+// so it is dumped as --data-binary @-, which reads the body from stdin
+func (resp *Response) DumpCurl() string {
+	req := resp.RawResponse.Request
+	if req == nil {
+		return ""
+	}
+
+	z := resp.z
+	options := resp.options
+	if z == nil {
+		z, options = &Zhttp{options: &HTTPOptions{}}, &ReqOptions{}
+	}
+
+	if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+		return z.formatCurl(req, nil, true, resp.proxy, options)
+	}
+
+	body, _ := readRequestBody(req)
+
+	return z.formatCurl(req, body, false, resp.proxy, options)
+}
+
+// buildDumpRequest build the request in the same way as sending it, and read its body
+func (z *Zhttp) buildDumpRequest(method, rawURL string, options *ReqOptions) (*http.Request, []byte, error) {
+	rawURL, err := z.buildURL(rawURL, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := z.buildRequest(context.Background(), method, rawURL, options, options.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	z.addCookies(req, options)
+	z.addHeaders(req, options)
+
+	body, err := readRequestBody(req)
+	// the body read by GetBody is a new one, the unread body of req should be closed
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, body, nil
+}
+
+// readRequestBody read the body of request, and replace the body with a new reader
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	return data, err
+}
+
+// formatCurl format the request to a curl command line, proxyURL is the proxy used by the request.
+// The body is read from stdin if stdinBody is true, since it can not be reproduced
+func (z *Zhttp) formatCurl(req *http.Request, body []byte, stdinBody bool, proxyURL *url.URL, options *ReqOptions) string {
+	var buf strings.Builder
+	buf.WriteString("curl")
+
+	switch {
+	case req.Method == "HEAD":
+		buf.WriteString(" -I")
+	case req.Method != "GET" || len(body) > 0 || stdinBody:
+		buf.WriteString(" -X ")
+		buf.WriteString(shellQuote(req.Method))
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(shellQuote(req.URL.String()))
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		buf.WriteString(" -H ")
+		buf.WriteString(shellQuote("Host: " + req.Host))
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range req.Header[key] {
+			buf.WriteString(" -H ")
+			if value == "" {
+				// an empty value means removing the header, like the User-Agent when NoUA is set
+				buf.WriteString(shellQuote(key + ":"))
+			} else {
+				buf.WriteString(shellQuote(key + ": " + value))
+			}
+		}
+	}
+
+	if stdinBody {
+		buf.WriteString(" --data-binary @-")
+	} else if len(body) > 0 {
+		buf.WriteString(" --data-binary ")
+		buf.WriteString(shellQuote(string(body)))
+	}
+
+	if !options.DisableRedirect {
+		buf.WriteString(" -L")
+	}
+
+	if proxyURL != nil {
+		buf.WriteString(" -x ")
+		buf.WriteString(shellQuote(proxyURL.String()))
+	}
+
+	if options.HostIP != "" {
+		port := req.URL.Port()
+		if port == "" {
+			port = "80"
+			if req.URL.Scheme == "https" {
+				port = "443"
+			}
+		}
+		ip := options.HostIP
+		if strings.Contains(ip, ":") {
+			ip = "[" + ip + "]"
+		}
+		buf.WriteString(" --resolve ")
+		buf.WriteString(shellQuote(req.URL.Hostname() + ":" + port + ":" + ip))
+	}
+
+	if z.options.InsecureSkipVerify || options.InsecureSkipVerify {
+		buf.WriteString(" -k")
+	}
+
+	timeout := z.options.RequestTimeout
+	if options.RequestTimeout > 0 {
+		timeout = options.RequestTimeout
+	}
+	if timeout > 0 {
+		buf.WriteString(" -m ")
+		buf.WriteString(strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+	}
+
+	return buf.String()
+}
+
+// proxyForDump return the proxy which would be used by the request.
+// The proxy of ProxyPool is not known until the request is sent, nil is returned for it
+// instead of picking one, so that dumping does not affect the pool
+func (z *Zhttp) proxyForDump(req *http.Request, options *ReqOptions) *url.URL {
+	ctx := context.WithValue(context.Background(), ctxOptionKey, options)
+	choice, err := selectProxy(z.options, req.WithContext(ctx), false)
+	if err != nil {
+		return nil
+	}
+	return choice.url
+}
+
+// shellQuote quote s for POSIX shell, the string which contains
+// control characters or invalid utf-8 is quoted by $'...'
+func shellQuote(s string) string {
+	plain := utf8.ValidString(s)
+	safe := s != ""
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			plain = false
+		}
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r)) {
+			safe = false
+		}
+	}
+
+	if safe {
+		return s
+	}
+
+	if plain {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	var buf strings.Builder
+	buf.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' || c == '\'':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\t':
+			buf.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('\'')
+
+	return buf.String()
+}
@@ -0,0 +1,71 @@
+package zhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDumpCurlProxy(t *testing.T) {
+	// the http proxies reply the requests by themselves
+	var proxies []*url.URL
+	for i := 0; i < 2; i++ {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		proxies = append(proxies, u)
+	}
+
+	pool := NewProxyPool(proxies, nil)
+	defer pool.Close()
+	z := New(&HTTPOptions{ProxyPool: pool})
+
+	used := func() []int64 {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		var list []int64
+		for _, pp := range pool.proxies {
+			list = append(list, pp.used)
+		}
+		return list
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := z.Get("http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Close()
+
+		before := used()
+		cmd := resp.DumpCurl()
+		if want := " -x " + proxies[i].String(); !strings.Contains(cmd, want) {
+			t.Errorf("DumpCurl() = %s, want %s", cmd, want)
+		}
+		if after := used(); after[0] != before[0] || after[1] != before[1] {
+			t.Errorf("dumping the response changes the pool usage from %v to %v", before, after)
+		}
+	}
+
+	before := used()
+	cmd, err := z.DumpCurl("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cmd, " -x ") {
+		t.Errorf("DumpCurl() = %s, want no proxy of the pool", cmd)
+	}
+	if after := used(); after[0] != before[0] || after[1] != before[1] {
+		t.Errorf("dumping the request changes the pool usage from %v to %v", before, after)
+	}
+
+	cmd, err = z.DumpCurl("GET", "http://example.com/", &ReqOptions{Proxies: map[string]*url.URL{"http": proxies[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := " -x " + proxies[1].String(); !strings.Contains(cmd, want) {
+		t.Errorf("DumpCurl() = %s, want %s", cmd, want)
+	}
+}
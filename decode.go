@@ -0,0 +1,163 @@
+package zhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Decoder decode the content read from r into v
+type Decoder func(r io.Reader, v interface{}) error
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		"application/json": decodeJSON,
+		"application/xml":  decodeXML,
+		"text/json":        decodeJSON,
+		"text/xml":         decodeXML,
+	}
+)
+
+// RegisterDecoder register the decoder for the media type such as application/msgpack,
+// it is used by Response.Decode. The decoder of the same media type is replaced
+func RegisterDecoder(mediaType string, decoder Decoder) {
+	decodersMu.Lock()
+	decoders[strings.ToLower(mediaType)] = decoder
+	decodersMu.Unlock()
+}
+
+// lookupDecoder return the decoder of the content type.
+// The media type with suffix +json or +xml, such as application/problem+json, fallback to json or xml
+func lookupDecoder(contentType string) (Decoder, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("zhttp: invalid content type %q", contentType)
+	}
+
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	if d, ok := decoders[mediaType]; ok {
+		return d, nil
+	}
+
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		if d, ok := decoders["application/"+mediaType[i+1:]]; ok {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("zhttp: no decoder for content type %q", contentType)
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func decodeXML(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// DecodeError is returned when the response body can not be decoded
+type DecodeError struct {
+	StatusCode  int
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("zhttp: decode response body failed (status code %d, content type %q): %v",
+		e.StatusCode, e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// JSON decode the body as json into v
+func (resp *Response) JSON(v interface{}) error {
+	return resp.decode(decodeJSON, v)
+}
+
+// XML decode the body as xml into v
+func (resp *Response) XML(v interface{}) error {
+	return resp.decode(decodeXML, v)
+}
+
+// Decode decode the body into v by the decoder of Content-Type,
+// json and xml are supported by default, others can be added by RegisterDecoder
+func (resp *Response) Decode(v interface{}) error {
+	decoder, err := lookupDecoder(resp.Headers.Get("Content-Type"))
+	if err != nil {
+		return &DecodeError{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Headers.Get("Content-Type"),
+			Err:         err,
+		}
+	}
+
+	return resp.decode(decoder, v)
+}
+
+// decode decode the body by decoder.
+// If the body is not cached by String, Bytes or ReadN, it is decoded from the stream
+// without buffering, and can not be read again.
+// The error of reading body is returned as it is, and also set to Body.Err
+func (resp *Response) decode(decoder Decoder, v interface{}) error {
+	b := resp.Body
+	if b.Err != nil {
+		return b.Err
+	}
+
+	var r io.Reader
+	var er *errRecorder
+	if b.bufCached {
+		r = bytes.NewReader(b.buf.Bytes())
+	} else {
+		er = &errRecorder{r: io.MultiReader(bytes.NewReader(b.buf.Bytes()), b.rawBody)}
+		r = er
+	}
+
+	err := decoder(r, v)
+
+	if er != nil {
+		b.bufCached = true
+		b.ClearCache()
+		b.rawBody.Close()
+
+		if er.err != nil {
+			b.Err = er.err
+			return er.err
+		}
+	}
+
+	if err != nil {
+		return &DecodeError{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Headers.Get("Content-Type"),
+			Err:         err,
+		}
+	}
+
+	return nil
+}
+
+// errRecorder record the first non-EOF error of reader
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package zhttp
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// IPFamily is the address family of the ips to connect
+type IPFamily int
+
+const (
+	// IPDefault dial the ips in the order of dns resolution, with both ipv4 and ipv6
+	IPDefault IPFamily = iota
+	// IPv4Only dial the ipv4 addresses only
+	IPv4Only
+	// IPv6Only dial the ipv6 addresses only
+	IPv6Only
+	// PreferIPv4 dial the ipv4 addresses first, and fall back to ipv6
+	PreferIPv4
+	// PreferIPv6 dial the ipv6 addresses first, and fall back to ipv4
+	PreferIPv6
+)
+
+// connectionAttemptDelay is the delay between the connection attempts of Happy Eyeballs, recommended by RFC 8305
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// sortIPs filter the ips by family, and interleave the ipv4 and ipv6 addresses
+// starting with the preferred family as RFC 8305 section 4
+func sortIPs(ips []net.IP, family IPFamily) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	var first, second []net.IP
+	switch family {
+	case IPv4Only:
+		return v4
+	case IPv6Only:
+		return v6
+	case PreferIPv4:
+		first, second = v4, v6
+	case PreferIPv6:
+		first, second = v6, v4
+	default:
+		// the family of the first ip is preferred
+		first, second = v4, v6
+		if len(ips) > 0 && ips[0].To4() == nil {
+			first, second = v6, v4
+		}
+	}
+
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+
+	return sorted
+}
+
+// dialHappyEyeballs dial the ips in order by Happy Eyeballs (RFC 8305).
+// The next ip is dialed when the previous attempt fails or does not complete in connectionAttemptDelay,
+// the first established connection is returned and the other attempts are canceled
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, network string, ips []net.IP, port string) (net.Conn, error) {
+	if len(ips) == 1 {
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- result{conn: conn, err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// close the connections established by the attempts which lose the race
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+
+			// the failed attempt starts the next one immediately
+			if next < len(ips) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				start()
+				timer.Reset(connectionAttemptDelay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+
+	return nil, firstErr
+}
@@ -0,0 +1,279 @@
+package zhttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// parseContentEncoding split the Content-Encoding header into the list of encodings in the order they were applied,
+// identity is ignored. ok is false if any encoding is not supported
+func parseContentEncoding(header string) (encodings []string, ok bool) {
+	for _, enc := range strings.Split(header, ",") {
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		switch enc {
+		case "", "identity":
+			continue
+		case "x-gzip":
+			enc = "gzip"
+		case "gzip", "deflate", "br", "zstd":
+		default:
+			return nil, false
+		}
+		encodings = append(encodings, enc)
+	}
+
+	return encodings, true
+}
+
+// countingReader count the bytes read from the underlying reader
+type countingReader struct {
+	rc io.ReadCloser
+	n  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.rc.Close()
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// decodingReader decode the body with the encodings, the decoders are created on first read,
+// so that creating it does not block on reading the header of the compressed stream
+type decodingReader struct {
+	rc        io.ReadCloser
+	encodings []string
+
+	r       io.Reader
+	closers []func()
+	err     error
+}
+
+func (d *decodingReader) init() error {
+	var r io.Reader = d.rc
+
+	// the last applied encoding is decoded first
+	for i := len(d.encodings) - 1; i >= 0; i-- {
+		switch d.encodings[i] {
+		case "gzip":
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, func() { zr.Close() })
+			r = zr
+		case "deflate":
+			fr, err := newDeflateReader(r)
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, func() { fr.Close() })
+			r = fr
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, zr.Close)
+			r = zr
+		}
+	}
+
+	d.r = r
+
+	return nil
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if d.r == nil {
+		err := d.init()
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+	}
+
+	return d.r.Read(p)
+}
+
+func (d *decodingReader) Close() error {
+	for _, c := range d.closers {
+		c()
+	}
+	return d.rc.Close()
+}
+
+// newDeflateReader create the reader of deflate encoding.
+// The deflate encoding should be zlib format, but some servers send raw deflate data
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// decodeBody wrap the body with the decoders of Content-Encoding,
+// the returned counter counts the compressed bytes, it is nil if the body is not decoded
+func (z *Zhttp) decodeBody(resp *http.Response, body io.ReadCloser) (io.ReadCloser, *countingReader) {
+	if z.options.DisableDecompression || resp.Uncompressed {
+		return body, nil
+	}
+	if resp.Request != nil && resp.Request.Method == "HEAD" {
+		return body, nil
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return body, nil
+	}
+
+	encodings, ok := parseContentEncoding(resp.Header.Get("Content-Encoding"))
+	if !ok || len(encodings) == 0 {
+		return body, nil
+	}
+
+	counter := &countingReader{rc: body}
+
+	return &decodingReader{rc: counter, encodings: encodings}, counter
+}
+
+// newDecodedResponse create the Response whose body is decoded by Content-Encoding if necessary
+func (z *Zhttp) newDecodedResponse(resp *http.Response, body io.ReadCloser) *Response {
+	decoded, counter := z.decodeBody(resp, body)
+
+	r := newResponse(resp, decoded)
+	r.ContentEncoding = resp.Header.Get("Content-Encoding")
+	if resp.Uncompressed {
+		// decoded by go automatically
+		r.ContentEncoding = "gzip"
+	}
+
+	if counter != nil {
+		r.compressed = counter
+		r.ContentLength = -1
+		// the headers describe the encoded body, which are removed like the gzip decoded by go
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+	}
+
+	return r
+}
+
+// acceptEncoding set the Accept-Encoding header by HTTPOptions.AcceptEncodings if it is not set
+func (z *Zhttp) acceptEncoding(req *http.Request) {
+	if len(z.options.AcceptEncodings) == 0 {
+		return
+	}
+
+	// the custom headers are set without canonicalizing the key
+	for key := range req.Header {
+		if strings.EqualFold(key, "Accept-Encoding") {
+			return
+		}
+	}
+
+	req.Header.Set("Accept-Encoding", strings.Join(z.options.AcceptEncodings, ", "))
+}
+
+// Compress wrap the body to be compressed by encoding while sending, and set the Content-Encoding header.
+// The encoding can be gzip, deflate, br and zstd.
+// The request can be replayed for redirects if the body is created by String, Bytes, JSON, XML and so on
+func Compress(body Body, encoding string) Body {
+	return &CompressedBody{
+		Body:     body,
+		Encoding: encoding,
+	}
+}
+
+type CompressedBody struct {
+	Body     Body
+	Encoding string
+}
+
+// ContentEncoding return the value of Content-Encoding header
+func (body *CompressedBody) ContentEncoding() string {
+	return strings.ToLower(body.Encoding)
+}
+
+func (body *CompressedBody) Content() (io.Reader, string, error) {
+	if body.Body == nil {
+		return nil, "", errors.New("zhttp: the body to be compressed is nil")
+	}
+
+	encoding := body.ContentEncoding()
+	switch encoding {
+	case "gzip", "deflate", "br", "zstd":
+	default:
+		return nil, "", fmt.Errorf("zhttp: unsupported content encoding %q", encoding)
+	}
+
+	reader, contentType, err := body.Body.Content()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the goroutine exits when the returned reader is closed, even if it is not read
+	pr, pw := io.Pipe()
+
+	go func() {
+		w, err := newCompressWriter(pw, encoding)
+		if err == nil {
+			_, err = io.Copy(w, reader)
+			if err == nil {
+				err = w.Close()
+			}
+		}
+		// stop the producer of the body, such as MultipartStream, when the request is aborted
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, contentType, nil
+}
+
+// newCompressWriter create the writer which compress the data by encoding
+func newCompressWriter(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		return zlib.NewWriter(w), nil
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+
+	return nil, fmt.Errorf("zhttp: unsupported content encoding %q", encoding)
+}
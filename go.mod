@@ -3,6 +3,10 @@ module github.com/greyh4t/zhttp
 go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/greyh4t/dnscache v0.0.0-20200422032442-29453c061c08
+	github.com/klauspost/compress v1.16.7
 	golang.org/x/net v0.17.0
 )
+
+require golang.org/x/text v0.13.0 // indirect
@@ -0,0 +1,620 @@
+package zhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultHARBodyLimit is the default maximum size of each body recorded by HARRecorder
+const DefaultHARBodyLimit = 1 << 20
+
+// HAR is a HTTP Archive 1.2 document.
+// See http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log *HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator *HARCreator `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+	Comment string      `json:"comment,omitempty"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time    `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         *HARRequest  `json:"request"`
+	Response        *HARResponse `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         *HARTimings  `json:"timings"`
+	ServerIPAddress string       `json:"serverIPAddress,omitempty"`
+	Comment         string       `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	HTTPVersion string          `json:"httpVersion"`
+	Cookies     []*HARCookie    `json:"cookies"`
+	Headers     []*HARNameValue `json:"headers"`
+	QueryString []*HARNameValue `json:"queryString"`
+	PostData    *HARPostData    `json:"postData,omitempty"`
+	HeadersSize int64           `json:"headersSize"`
+	BodySize    int64           `json:"bodySize"`
+	Comment     string          `json:"comment,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int             `json:"status"`
+	StatusText  string          `json:"statusText"`
+	HTTPVersion string          `json:"httpVersion"`
+	Cookies     []*HARCookie    `json:"cookies"`
+	Headers     []*HARNameValue `json:"headers"`
+	Content     *HARContent     `json:"content"`
+	RedirectURL string          `json:"redirectURL"`
+	HeadersSize int64           `json:"headersSize"`
+	BodySize    int64           `json:"bodySize"`
+	Comment     string          `json:"comment,omitempty"`
+}
+
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string      `json:"mimeType"`
+	Params   []*HARParam `json:"params,omitempty"`
+	Text     string      `json:"text"`
+	Comment  string      `json:"comment,omitempty"`
+}
+
+type HARParam struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings is the timings of an entry in milliseconds, -1 means the phase does not apply
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// LoadHAR decode a HAR document from r
+func LoadHAR(r io.Reader) (*HAR, error) {
+	var har HAR
+	err := json.NewDecoder(r).Decode(&har)
+	if err != nil {
+		return nil, err
+	}
+
+	if har.Log == nil {
+		har.Log = &HARLog{}
+	}
+
+	return &har, nil
+}
+
+// ReqOptions convert the recorded request to method, url and *ReqOptions
+// which can be sent by Zhttp.Request. Redirects are disabled, because
+// every redirect hop is recorded as a separate entry
+func (r *HARRequest) ReqOptions() (string, string, *ReqOptions) {
+	options := &ReqOptions{
+		DisableRedirect: true,
+		NoUA:            true,
+		Headers:         map[string]string{},
+	}
+
+	u, _ := url.Parse(r.URL)
+
+	for _, h := range r.Headers {
+		name := h.Name
+		switch {
+		case strings.HasPrefix(name, ":"),
+			strings.EqualFold(name, "Content-Length"),
+			strings.EqualFold(name, "Cookie"):
+			continue
+		case strings.EqualFold(name, "Host"):
+			if u == nil || h.Value != u.Host {
+				options.Host = h.Value
+			}
+			continue
+		case strings.EqualFold(name, "User-Agent"):
+			options.NoUA = false
+			options.UserAgent = h.Value
+			continue
+		}
+
+		if value, ok := options.Headers[name]; ok {
+			options.Headers[name] = value + ", " + h.Value
+		} else {
+			options.Headers[name] = h.Value
+		}
+	}
+
+	if len(r.Cookies) > 0 {
+		options.Cookies = make(map[string]string, len(r.Cookies))
+		for _, c := range r.Cookies {
+			options.Cookies[c.Name] = c.Value
+		}
+	}
+
+	if r.PostData != nil {
+		if r.PostData.Text == "" && len(r.PostData.Params) > 0 {
+			values := url.Values{}
+			for _, p := range r.PostData.Params {
+				values.Add(p.Name, p.Value)
+			}
+			options.Body = FormValues(values)
+		} else {
+			options.Body = String(r.PostData.Text)
+		}
+		options.ContentType = r.PostData.MimeType
+	}
+
+	return r.Method, r.URL, options
+}
+
+// ReplayHAR send the requests of all entries in har in order.
+// fn is called with the result of each entry, the response must be closed by fn.
+// If fn return false, the replay is stopped
+func (z *Zhttp) ReplayHAR(ctx context.Context, har *HAR, fn func(entry *HAREntry, resp *Response, err error) bool) error {
+	for _, entry := range har.Log.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.Request == nil {
+			continue
+		}
+
+		method, url, options := entry.Request.ReqOptions()
+		resp, err := z.RequestContext(ctx, method, url, options)
+		if !fn(entry, resp, err) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// HARRecorder records the requests and responses into a HAR document.
+// Register it with Use of Zhttp or Session, or ReqOptions.Middlewares:
+//
+//	recorder := zhttp.NewHARRecorder(0)
+//	z.Use(recorder.Middleware())
+//
+// Every attempt and every redirect hop is recorded as a separate entry.
+// The response body is recorded while it is being read,
+// so it should be read or closed before exporting the document.
+type HARRecorder struct {
+	bodyLimit int64
+	mu        sync.Mutex
+	entries   []*harRecord
+}
+
+// NewHARRecorder create a HARRecorder, bodyLimit is the maximum size of each body to record.
+// If zero, DefaultHARBodyLimit is used, if negative, bodies will not be recorded
+func NewHARRecorder(bodyLimit int64) *HARRecorder {
+	if bodyLimit == 0 {
+		bodyLimit = DefaultHARBodyLimit
+	}
+
+	return &HARRecorder{bodyLimit: bodyLimit}
+}
+
+// Middleware return the middleware which records the traffic
+func (r *HARRecorder) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*Response, error) {
+			reqBody := r.captureRequestBody(req)
+
+			resp, err := next(req)
+			if err != nil {
+				r.add(&harRecord{
+					start:   time.Now(),
+					req:     req,
+					reqBody: reqBody,
+					err:     err,
+				})
+				return nil, err
+			}
+
+			r.record(req, reqBody, resp)
+
+			return resp, nil
+		}
+	}
+}
+
+// Reset remove all recorded entries
+func (r *HARRecorder) Reset() {
+	r.mu.Lock()
+	r.entries = nil
+	r.mu.Unlock()
+}
+
+// HAR return a HAR document of all recorded entries
+func (r *HARRecorder) HAR() *HAR {
+	r.mu.Lock()
+	records := make([]*harRecord, len(r.entries))
+	copy(records, r.entries)
+	r.mu.Unlock()
+
+	entries := make([]*HAREntry, len(records))
+	for i, record := range records {
+		entries[i] = record.entry()
+	}
+
+	return &HAR{
+		Log: &HARLog{
+			Version: "1.2",
+			Creator: &HARCreator{Name: "zhttp", Version: "2.0"},
+			Entries: entries,
+		},
+	}
+}
+
+// Export write the HAR document of all recorded entries to w in json format
+func (r *HARRecorder) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.HAR())
+}
+
+func (r *HARRecorder) add(record *harRecord) {
+	r.mu.Lock()
+	r.entries = append(r.entries, record)
+	r.mu.Unlock()
+}
+
+func (r *HARRecorder) captureRequestBody(req *http.Request) *bodyCapture {
+	if r.bodyLimit < 0 || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	capture := &bodyCapture{limit: r.bodyLimit}
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err == nil {
+			io.Copy(capture, rc)
+			rc.Close()
+			return capture
+		}
+	}
+
+	// the body can only be read once, record it while it is being sent
+	req.Body = &teeReadCloser{ReadCloser: req.Body, w: capture}
+
+	return capture
+}
+
+// record add all round trips of the response, including the redirect hops
+func (r *HARRecorder) record(req *http.Request, reqBody *bodyCapture, resp *Response) {
+	var hops []*http.Response
+	for raw := resp.RawResponse; raw != nil; {
+		hops = append(hops, raw)
+		if raw.Request == nil {
+			break
+		}
+		raw = raw.Request.Response
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		record := &harRecord{
+			start: time.Now(),
+			req:   hops[i].Request,
+			resp:  hops[i],
+		}
+		if record.req == nil {
+			record.req = req
+		}
+
+		if i == len(hops)-1 {
+			record.reqBody = reqBody
+		}
+
+		// index of the hop in the round trips of the response
+		hop := len(hops) - 1 - i
+		if i == 0 {
+			record.timings = resp.Timings
+		} else if hop < len(resp.RedirectTimings) {
+			record.timings = resp.RedirectTimings[hop]
+		}
+		if record.timings != nil {
+			record.start = record.timings.Start
+		}
+
+		if i == 0 && r.bodyLimit >= 0 {
+			record.respBody = &bodyCapture{limit: r.bodyLimit}
+			resp.Body.rawBody = &teeReadCloser{ReadCloser: resp.Body.rawBody, w: record.respBody}
+		}
+
+		r.add(record)
+	}
+}
+
+// harRecord is a recorded round trip, it is converted to HAREntry when exporting,
+// so that the body and timings which are filled later can be included
+type harRecord struct {
+	start    time.Time
+	req      *http.Request
+	reqBody  *bodyCapture
+	resp     *http.Response
+	respBody *bodyCapture
+	timings  *Timings
+	err      error
+}
+
+func (record *harRecord) entry() *HAREntry {
+	entry := &HAREntry{
+		StartedDateTime: record.start,
+		Request:         harRequest(record.req, record.reqBody),
+		Response: &HARResponse{
+			Cookies:     []*HARCookie{},
+			Headers:     []*HARNameValue{},
+			Content:     &HARContent{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: &HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+
+	if record.err != nil {
+		entry.Response.Comment = record.err.Error()
+	}
+
+	if record.resp != nil {
+		entry.Response = harResponse(record.resp, record.respBody)
+		entry.Request.HTTPVersion = record.resp.Proto
+	}
+
+	if t := record.timings; t != nil {
+		entry.Timings = harTimings(t)
+		entry.ServerIPAddress = hostOfAddr(t.RemoteAddr)
+		entry.Time = milliseconds(t.Total)
+	}
+
+	return entry
+}
+
+func harRequest(req *http.Request, body *bodyCapture) *HARRequest {
+	r := &HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: []*HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		r.Headers = append(r.Headers, &HARNameValue{Name: "Host", Value: req.Host})
+	}
+
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			r.QueryString = append(r.QueryString, &HARNameValue{Name: key, Value: value})
+		}
+	}
+
+	if body != nil {
+		data, size, truncated := body.snapshot()
+		if r.BodySize < 0 {
+			r.BodySize = size
+		}
+
+		r.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     string(data),
+		}
+		if truncated {
+			r.PostData.Comment = "truncated"
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.PostData.MimeType)
+		if mediaType == "application/x-www-form-urlencoded" && !truncated {
+			values, err := url.ParseQuery(r.PostData.Text)
+			if err == nil {
+				for key, list := range values {
+					for _, value := range list {
+						r.PostData.Params = append(r.PostData.Params, &HARParam{Name: key, Value: value})
+					}
+				}
+			}
+		}
+	} else if r.BodySize < 0 {
+		r.BodySize = 0
+	}
+
+	return r
+}
+
+func harResponse(resp *http.Response, body *bodyCapture) *HARResponse {
+	r := &HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content: &HARContent{
+			Size:     0,
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+
+	if i := strings.IndexByte(resp.Status, ' '); i >= 0 {
+		r.StatusText = resp.Status[i+1:]
+	}
+
+	if body != nil {
+		data, size, truncated := body.snapshot()
+		r.Content.Size = size
+		r.BodySize = size
+		if utf8.Valid(data) {
+			r.Content.Text = string(data)
+		} else {
+			r.Content.Text = base64.StdEncoding.EncodeToString(data)
+			r.Content.Encoding = "base64"
+		}
+		if truncated {
+			r.Content.Comment = "truncated"
+		}
+	}
+
+	return r
+}
+
+func harHeaders(header http.Header) []*HARNameValue {
+	list := []*HARNameValue{}
+	for key, values := range header {
+		for _, value := range values {
+			list = append(list, &HARNameValue{Name: key, Value: value})
+		}
+	}
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []*HARCookie {
+	list := make([]*HARCookie, len(cookies))
+	for i, c := range cookies {
+		list[i] = &HARCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			list[i].Expires = &expires
+		}
+	}
+	return list
+}
+
+func harTimings(t *Timings) *HARTimings {
+	optional := func(d time.Duration) float64 {
+		if d == 0 {
+			return -1
+		}
+		return milliseconds(d)
+	}
+
+	timings := &HARTimings{
+		Blocked: -1,
+		DNS:     optional(t.DNSLookup),
+		Connect: optional(t.TCPConnect + t.TLSHandshake),
+		SSL:     optional(t.TLSHandshake),
+		Send:    milliseconds(t.RequestSend),
+		Wait:    milliseconds(t.ServerProcessing),
+		Receive: milliseconds(t.BodyTransfer),
+	}
+
+	return timings
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func hostOfAddr(addr string) string {
+	if i := strings.LastIndexByte(addr, ':'); i >= 0 {
+		return strings.Trim(addr[:i], "[]")
+	}
+	return addr
+}
+
+// bodyCapture keeps the first limit bytes written to it, and counts the total size
+type bodyCapture struct {
+	mu    sync.Mutex
+	limit int64
+	buf   bytes.Buffer
+	size  int64
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size += int64(len(p))
+	if remain := c.limit - int64(c.buf.Len()); remain > 0 {
+		if int64(len(p)) > remain {
+			c.buf.Write(p[:remain])
+		} else {
+			c.buf.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *bodyCapture) snapshot() ([]byte, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data := append([]byte(nil), c.buf.Bytes()...)
+	return data, c.size, c.size > int64(c.buf.Len())
+}
+
+// teeReadCloser writes to w what it reads from ReadCloser
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	return n, err
+}
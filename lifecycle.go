@@ -0,0 +1,111 @@
+package zhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ErrClosed is returned by the requests of Zhttp which has been closed
+var ErrClosed = errors.New("zhttp: client is closed")
+
+// Close close the idle connections, stop the dns cache created by Zhttp, and reject the new requests with ErrClosed.
+// The requests in flight are not interrupted, their connections are closed after they finish.
+// It is safe to call Close more than once
+func (z *Zhttp) Close() error {
+	z.mu.Lock()
+	if z.closed {
+		z.mu.Unlock()
+		return nil
+	}
+	z.closed = true
+	if z.idle == nil {
+		z.idle = make(chan struct{})
+	}
+	if z.inflight == 0 {
+		close(z.idle)
+	}
+	z.mu.Unlock()
+
+	runtime.SetFinalizer(z, nil)
+	z.releaseResources()
+
+	return nil
+}
+
+// Shutdown close Zhttp like Close, and wait for the requests in flight to finish.
+// A request is in flight until the body of its response is read to the end or closed.
+// If ctx is done before that, the error of ctx is returned
+func (z *Zhttp) Shutdown(ctx context.Context) error {
+	z.Close()
+
+	select {
+	case <-z.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire count a request in flight, ErrClosed is returned if Zhttp is closed
+func (z *Zhttp) acquire() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.closed {
+		return ErrClosed
+	}
+	z.inflight++
+
+	return nil
+}
+
+// release finish a request in flight, the connections are closed if it is the last one after Zhttp is closed
+func (z *Zhttp) release() {
+	z.mu.Lock()
+	z.inflight--
+	last := z.closed && z.inflight == 0
+	if last {
+		close(z.idle)
+	}
+	z.mu.Unlock()
+
+	if last {
+		z.roundTripper.CloseIdleConnections()
+	}
+}
+
+// track release the request when the body of resp is read to the end or closed,
+// or immediately if the request fails
+func (z *Zhttp) track(resp *Response, err error) (*Response, error) {
+	if err != nil {
+		z.release()
+		return nil, err
+	}
+
+	resp.Body.rawBody = &releaseReadCloser{ReadCloser: resp.Body.rawBody, release: z.release}
+	return resp, nil
+}
+
+// releaseReadCloser call release once when it is read to the end, fails or is closed
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releaseReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.once.Do(r.release)
+	}
+	return n, err
+}
+
+func (r *releaseReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
@@ -0,0 +1,55 @@
+package zhttp
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	errNilResponse = errors.New("zhttp: middleware returned nil response without error")
+	errNilBody     = errors.New("zhttp: middleware returned response without body, build it by NewResponse")
+)
+
+// RoundTripFunc send a fully built *http.Request and return the *Response
+type RoundTripFunc func(req *http.Request) (*Response, error)
+
+// Middleware wraps a RoundTripFunc to intercept the request and response.
+// It can modify the request before calling next, inspect or replace the response
+// returned by next, or return a response without calling next to short-circuit the request.
+// The response returned without calling next should be built by NewResponse, so that it has a Body.
+//
+// Middlewares are applied in the following order, the former wraps the latter:
+// middlewares of Zhttp, middlewares of Session, ReqOptions.Middlewares.
+// In each group, the middleware registered first is the outermost one.
+// Middlewares are called on every attempt when the request is retried.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Use register middlewares to Zhttp, they will be applied to all requests,
+// including the requests sent by the sessions created from it.
+// It should be called before sending requests, and it is not safe for concurrent use.
+func (z *Zhttp) Use(middlewares ...Middleware) {
+	z.middlewares = append(z.middlewares, middlewares...)
+}
+
+// Use register middlewares to Session, they will be applied to all requests of the session.
+// It should be called before sending requests, and it is not safe for concurrent use.
+func (s *Session) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// chainMiddlewares wraps the final RoundTripFunc with all middlewares
+func (z *Zhttp) chainMiddlewares(final RoundTripFunc, s *Session, options *ReqOptions) RoundTripFunc {
+	groups := [][]Middleware{z.middlewares, nil, options.Middlewares}
+	if s != nil {
+		groups[1] = s.middlewares
+	}
+
+	next := final
+	for i := len(groups) - 1; i >= 0; i-- {
+		for j := len(groups[i]) - 1; j >= 0; j-- {
+			next = groups[i][j](next)
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,44 @@
+package zhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	z := New(nil)
+	defer z.Close()
+
+	reply := func(resp *Response) Middleware {
+		return func(next RoundTripFunc) RoundTripFunc {
+			return func(req *http.Request) (*Response, error) {
+				return resp, nil
+			}
+		}
+	}
+
+	// the host is never connected
+	url := "http://127.0.0.1:1/"
+
+	cached := NewResponse(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("cached")),
+	})
+	resp, err := z.Get(url, &ReqOptions{Middlewares: []Middleware{reply(cached)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body.String() != "cached" {
+		t.Errorf("got body %q, want cached", resp.Body.String())
+	}
+
+	for _, resp := range []*Response{nil, {}, {Body: &ZBody{}}} {
+		_, err := z.Get(url, &ReqOptions{Middlewares: []Middleware{reply(resp)}})
+		if err == nil {
+			t.Errorf("got no error for response %+v", resp)
+		}
+	}
+}
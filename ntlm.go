@@ -0,0 +1,316 @@
+package zhttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM create an Authenticator which performs NTLMv2 authentication.
+// The username can be in the form of DOMAIN\user
+func NTLM(username, password string) Authenticator {
+	a := &NTLMAuth{Username: username, Password: password}
+	if i := strings.IndexByte(username, '\\'); i >= 0 {
+		a.Domain = username[:i]
+		a.Username = username[i+1:]
+	}
+	return a
+}
+
+// NTLMAuth performs NTLMv2 authentication.
+// NTLM authenticates the connection instead of the request, the handshake needs the connection to be kept alive,
+// so it does not work with HTTPOptions.DisableKeepAlives
+type NTLMAuth struct {
+	Domain      string
+	Username    string
+	Password    string
+	Workstation string
+}
+
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmNegotiateOEM              = 0x00000002
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+
+	// ntlmNegotiateFlags is the flags sent in the negotiate message
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo |
+		ntlmNegotiate128 | ntlmNegotiate56
+)
+
+// the ids of AV_PAIR in target info
+const (
+	ntlmAvEOL       uint16 = 0
+	ntlmAvTimestamp uint16 = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// Authenticate send the negotiate message
+func (a *NTLMAuth) Authenticate(req *http.Request) error {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+
+	req.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(msg))
+	return nil
+}
+
+// Challenge answer the challenge message with the authenticate message
+func (a *NTLMAuth) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	var challenge []byte
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		for _, c := range parseAuthChallenges(header) {
+			if strings.EqualFold(c.scheme, "NTLM") && c.token != "" {
+				data, err := base64.StdEncoding.DecodeString(c.token)
+				if err == nil {
+					challenge = data
+				}
+			}
+		}
+	}
+
+	// the credentials are rejected
+	if challenge == nil {
+		return false, nil
+	}
+
+	clientChallenge := make([]byte, 8)
+	_, err := rand.Read(clientChallenge)
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := a.authenticateMessage(challenge, clientChallenge, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(msg))
+	return true, nil
+}
+
+// authenticateMessage build the authenticate message from the challenge message,
+// now is used as the timestamp if the server does not provide it
+func (a *NTLMAuth) authenticateMessage(challenge, clientChallenge []byte, now time.Time) ([]byte, error) {
+	if len(challenge) < 32 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("zhttp: invalid ntlm challenge message")
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+
+	var targetInfo []byte
+	if len(challenge) >= 48 {
+		var ok bool
+		targetInfo, ok = ntlmPayload(challenge, 40)
+		if !ok {
+			return nil, errors.New("zhttp: invalid ntlm target info")
+		}
+	}
+
+	// use the timestamp of server if it is provided
+	timestamp := make([]byte, 8)
+	hasTimestamp := false
+	if v, ok := ntlmAvPair(targetInfo, ntlmAvTimestamp); ok && len(v) == 8 {
+		copy(timestamp, v)
+		hasTimestamp = true
+	} else {
+		// windows file time, 100ns since 1601-01-01
+		binary.LittleEndian.PutUint64(timestamp, uint64(now.Unix()+11644473600)*10000000+uint64(now.Nanosecond()/100))
+	}
+
+	ntowf := ntowfv2(a.Username, a.Password, a.Domain)
+
+	var temp bytes.Buffer
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	ntProof := hmacMD5(ntowf, serverChallenge, temp.Bytes())
+	ntResponse := append(ntProof, temp.Bytes()...)
+
+	lmResponse := make([]byte, 24)
+	if !hasTimestamp {
+		lmResponse = append(hmacMD5(ntowf, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	unicode := flags&ntlmNegotiateUnicode != 0
+	domain := ntlmString(a.Domain, unicode)
+	user := ntlmString(a.Username, unicode)
+	workstation := ntlmString(a.Workstation, unicode)
+
+	// negotiate the flags supported by both sides, the key exchange is not used
+	responseFlags := flags & ntlmNegotiateFlags
+	if unicode {
+		responseFlags &^= ntlmNegotiateOEM
+	}
+
+	const headerLen = 64
+	msg := make([]byte, headerLen)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+
+	payloads := [][]byte{lmResponse, ntResponse, domain, user, workstation, nil}
+	offset := headerLen
+	for i, p := range payloads {
+		field := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[field:], uint16(len(p)))
+		binary.LittleEndian.PutUint16(msg[field+2:], uint16(len(p)))
+		binary.LittleEndian.PutUint32(msg[field+4:], uint32(offset))
+		offset += len(p)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], responseFlags)
+
+	for _, p := range payloads {
+		msg = append(msg, p...)
+	}
+
+	return msg, nil
+}
+
+// ntlmPayload return the payload referred by the security buffer at offset
+func ntlmPayload(msg []byte, offset int) ([]byte, bool) {
+	length := int(binary.LittleEndian.Uint16(msg[offset:]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+	if length == 0 {
+		return nil, true
+	}
+	if start < 0 || start+length > len(msg) {
+		return nil, false
+	}
+	return msg[start : start+length], true
+}
+
+// ntlmAvPair return the value of the AV_PAIR with id in target info
+func ntlmAvPair(targetInfo []byte, id uint16) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		avID := binary.LittleEndian.Uint16(targetInfo)
+		avLen := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if avID == ntlmAvEOL || 4+avLen > len(targetInfo) {
+			break
+		}
+		if avID == id {
+			return targetInfo[4 : 4+avLen], true
+		}
+		targetInfo = targetInfo[4+avLen:]
+	}
+	return nil, false
+}
+
+func ntlmString(s string, unicode bool) []byte {
+	if unicode {
+		return utf16le(s)
+	}
+	return []byte(strings.ToUpper(s))
+}
+
+func utf16le(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, len(codes)*2)
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 compute the NTOWFv2 of the user
+func ntowfv2(user, password, domain string) []byte {
+	hash := md4Sum(utf16le(password))
+	return hmacMD5(hash[:], utf16le(strings.ToUpper(user)+domain))
+}
+
+// md4Sum compute the MD4 checksum of data, which is used by NTLM only
+func md4Sum(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	msg := make([]byte, len(data), len(data)+72)
+	copy(msg, data)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(data))*8)
+	msg = append(msg, length[:]...)
+
+	var x [16]uint32
+	for len(msg) > 0 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[i*4:])
+		}
+		msg = msg[64:]
+
+		aa, bb, cc, dd := a, b, c, d
+
+		r1 := func(a, b, c, d, x uint32, s int) uint32 {
+			return bits.RotateLeft32(a+(b&c|^b&d)+x, s)
+		}
+		for i := 0; i < 16; i += 4 {
+			a = r1(a, b, c, d, x[i], 3)
+			d = r1(d, a, b, c, x[i+1], 7)
+			c = r1(c, d, a, b, x[i+2], 11)
+			b = r1(b, c, d, a, x[i+3], 19)
+		}
+
+		r2 := func(a, b, c, d, x uint32, s int) uint32 {
+			return bits.RotateLeft32(a+(b&c|b&d|c&d)+x+0x5a827999, s)
+		}
+		for i := 0; i < 4; i++ {
+			a = r2(a, b, c, d, x[i], 3)
+			d = r2(d, a, b, c, x[i+4], 5)
+			c = r2(c, d, a, b, x[i+8], 9)
+			b = r2(b, c, d, a, x[i+12], 13)
+		}
+
+		r3 := func(a, b, c, d, x uint32, s int) uint32 {
+			return bits.RotateLeft32(a+(b^c^d)+x+0x6ed9eba1, s)
+		}
+		for _, i := range []int{0, 2, 1, 3} {
+			a = r3(a, b, c, d, x[i], 3)
+			d = r3(d, a, b, c, x[i+8], 9)
+			c = r3(c, d, a, b, x[i+4], 11)
+			b = r3(b, c, d, a, x[i+12], 15)
+		}
+
+		a += aa
+		b += bb
+		c += cc
+		d += dd
+	}
+
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+
+	return sum
+}
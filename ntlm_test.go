@@ -0,0 +1,157 @@
+package zhttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestMD4Sum(t *testing.T) {
+	// the test suite of RFC 1320
+	tests := []struct {
+		data string
+		sum  string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"a", "bde52cb31de33e46245e05fbdbd6fb24"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"abcdefghijklmnopqrstuvwxyz", "d79e1c308aa5bbcdeea8ed63df412da9"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "043f8582f241db351ce627e153e7f0e4"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+
+	for _, tt := range tests {
+		sum := md4Sum([]byte(tt.data))
+		if got := hex.EncodeToString(sum[:]); got != tt.sum {
+			t.Errorf("md4Sum(%q) = %s, want %s", tt.data, got, tt.sum)
+		}
+	}
+}
+
+// the NTLMv2 sample of MS-NLMP 4.2.4
+var (
+	ntlmTestServerChallenge = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	ntlmTestClientChallenge = []byte{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+)
+
+// ntlmTestChallengeMessage build the challenge message of MS-NLMP 4.2.4.3
+func ntlmTestChallengeMessage() []byte {
+	targetName := utf16le("Server")
+
+	var targetInfo []byte
+	for _, av := range []struct {
+		id    uint16
+		value string
+	}{{2, "Domain"}, {1, "Server"}} {
+		value := utf16le(av.value)
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header, av.id)
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+		targetInfo = append(targetInfo, header...)
+		targetInfo = append(targetInfo, value...)
+	}
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+
+	msg := make([]byte, 56)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:], 56)
+	binary.LittleEndian.PutUint32(msg[20:], 0xe28a8233)
+	copy(msg[24:], ntlmTestServerChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(56+len(targetName)))
+	copy(msg[48:], []byte{0x06, 0x00, 0x70, 0x17, 0x00, 0x00, 0x00, 0x0f})
+
+	msg = append(msg, targetName...)
+	return append(msg, targetInfo...)
+}
+
+func TestNTOWFv2(t *testing.T) {
+	got := hex.EncodeToString(ntowfv2("User", "Password", "Domain"))
+	if want := "0c868a403bfd7a93a3001ef22ef02e3f"; got != want {
+		t.Errorf("ntowfv2 = %s, want %s", got, want)
+	}
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	a := NTLM(`Domain\User`, "Password").(*NTLMAuth)
+	a.Workstation = "COMPUTER"
+
+	challenge := ntlmTestChallengeMessage()
+	msg, err := a.authenticateMessage(challenge, ntlmTestClientChallenge, time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		t.Fatalf("invalid message header %x", msg[:12])
+	}
+
+	fields := []struct {
+		name   string
+		offset int
+		want   string
+	}{
+		{"LmChallengeResponse", 12, "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"},
+		{"DomainName", 28, hex.EncodeToString(utf16le("Domain"))},
+		{"UserName", 36, hex.EncodeToString(utf16le("User"))},
+		{"Workstation", 44, hex.EncodeToString(utf16le("COMPUTER"))},
+	}
+	for _, f := range fields {
+		value, ok := ntlmPayload(msg, f.offset)
+		if !ok {
+			t.Fatalf("invalid %s", f.name)
+		}
+		if got := hex.EncodeToString(value); got != f.want {
+			t.Errorf("%s = %s, want %s", f.name, got, f.want)
+		}
+	}
+
+	ntResponse, ok := ntlmPayload(msg, 20)
+	if !ok || len(ntResponse) < 16 {
+		t.Fatal("invalid NtChallengeResponse")
+	}
+	if got, want := hex.EncodeToString(ntResponse[:16]), "68cd0ab851e51c96aabc927bebef6a1c"; got != want {
+		t.Errorf("NTProofStr = %s, want %s", got, want)
+	}
+
+	targetInfo, _ := ntlmPayload(challenge, 40)
+	var temp []byte
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = append(temp, make([]byte, 8)...)
+	temp = append(temp, ntlmTestClientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	if !bytes.Equal(ntResponse[16:], temp) {
+		t.Errorf("NTLMv2 client challenge = %x, want %x", ntResponse[16:], temp)
+	}
+
+	flags := binary.LittleEndian.Uint32(msg[60:])
+	if flags&ntlmNegotiateUnicode == 0 || flags&ntlmNegotiateOEM != 0 {
+		t.Errorf("invalid flags %08x", flags)
+	}
+}
+
+func TestNTLMAuthenticateMessageInvalid(t *testing.T) {
+	a := NTLM(`Domain\User`, "Password").(*NTLMAuth)
+
+	wrongType := ntlmTestChallengeMessage()
+	binary.LittleEndian.PutUint32(wrongType[8:], 3)
+
+	wrongOffset := ntlmTestChallengeMessage()
+	binary.LittleEndian.PutUint32(wrongOffset[44:], 0xffff)
+
+	for _, challenge := range [][]byte{nil, ntlmSignature, wrongType, wrongOffset} {
+		_, err := a.authenticateMessage(challenge, ntlmTestClientChallenge, time.Now())
+		if err == nil {
+			t.Errorf("authenticateMessage(%x) returned no error", challenge)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package zhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The grant types of OAuth2
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OAuth2Config is the config to get the access token of OAuth2
+type OAuth2Config struct {
+	// TokenURL is the token endpoint of the authorization server
+	TokenURL string
+
+	// GrantType is GrantClientCredentials, GrantPassword or GrantRefreshToken,
+	// default is GrantClientCredentials
+	GrantType string
+
+	ClientID     string
+	ClientSecret string
+
+	// ClientAuthInBody will send the client id and secret in the form instead of basic authentication
+	ClientAuthInBody bool
+
+	// Username and Password are used by GrantPassword
+	Username string
+	Password string
+
+	// RefreshToken is used by GrantRefreshToken.
+	// The refresh token returned by server is always used to refresh the token for all grant types
+	RefreshToken string
+
+	Scopes []string
+
+	// EndpointParams is the additional parameters sent to the token endpoint
+	EndpointParams map[string]string
+
+	// ExpiryDelta is the time before the token expires to refresh it, default is 30 seconds
+	ExpiryDelta time.Duration
+}
+
+// OAuth2Token is the token returned by the token endpoint
+type OAuth2Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+
+	// Expiry is the time when the token expires, zero means it never expires
+	Expiry time.Time
+}
+
+// OAuth2Error is the error returned by the token endpoint
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("zhttp: oauth2 token request failed with status code %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("zhttp: oauth2 token request failed with status code %d: %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("zhttp: oauth2 token request failed with status code %d: %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// OAuth2 is an Authenticator which sends the access token of OAuth2 as bearer token.
+// The token is got through the Zhttp which creates it, cached and refreshed before it expires.
+// When the response is 401, the token is refreshed and the request is sent again once
+type OAuth2 struct {
+	z      *Zhttp
+	config OAuth2Config
+
+	mu           sync.Mutex
+	token        *OAuth2Token
+	refreshToken string
+}
+
+var ctxOAuth2RetriedKey = &struct{ name string }{"oauth2 retried"}
+
+// NewOAuth2 create an OAuth2 Authenticator which gets the token through z
+func (z *Zhttp) NewOAuth2(config *OAuth2Config) *OAuth2 {
+	o := &OAuth2{z: z, config: *config}
+	if o.config.GrantType == "" {
+		o.config.GrantType = GrantClientCredentials
+	}
+	if o.config.ExpiryDelta <= 0 {
+		o.config.ExpiryDelta = 30 * time.Second
+	}
+	o.refreshToken = o.config.RefreshToken
+
+	return o
+}
+
+// UseOAuth2 authenticate all requests of the session by OAuth2, the token is got through the Zhttp of session
+func (s *Session) UseOAuth2(config *OAuth2Config) *OAuth2 {
+	o := s.z.NewOAuth2(config)
+	s.Authenticator = o
+	return o
+}
+
+// SetToken set the token to be used, such as the token saved before
+func (o *OAuth2) SetToken(token *OAuth2Token) {
+	o.mu.Lock()
+	o.token = token
+	if token != nil && token.RefreshToken != "" {
+		o.refreshToken = token.RefreshToken
+	}
+	o.mu.Unlock()
+}
+
+// Token return the cached token, a new token is got if it is about to expire
+func (o *OAuth2) Token(ctx context.Context) (*OAuth2Token, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token != nil && (o.token.Expiry.IsZero() || time.Until(o.token.Expiry) > o.config.ExpiryDelta) {
+		return o.token, nil
+	}
+
+	return o.fetch(ctx)
+}
+
+func (o *OAuth2) Authenticate(req *http.Request) error {
+	token, err := o.Token(req.Context())
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+// Challenge get a new token and send the request again once
+func (o *OAuth2) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	if retried, _ := resp.Request.Context().Value(ctxOAuth2RetriedKey).(bool); retried {
+		return false, nil
+	}
+
+	o.mu.Lock()
+	token := o.token
+	// the token may have been refreshed by another request
+	if token == nil || resp.Request.Header.Get("Authorization") == "Bearer "+token.AccessToken {
+		var err error
+		token, err = o.fetch(req.Context())
+		if err != nil {
+			o.mu.Unlock()
+			return false, err
+		}
+	}
+	o.mu.Unlock()
+
+	*req = *req.WithContext(context.WithValue(req.Context(), ctxOAuth2RetriedKey, true))
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	return true, nil
+}
+
+// fetch get a new token by refresh token if there is one, or by the grant type of config.
+// It must be called with mu held
+func (o *OAuth2) fetch(ctx context.Context) (*OAuth2Token, error) {
+	// the token request should not inherit the values of the request being authenticated
+	ctx = valuelessContext{ctx}
+
+	var token *OAuth2Token
+	var err error
+
+	if o.refreshToken != "" {
+		token, err = o.request(ctx, map[string]string{
+			"grant_type":    GrantRefreshToken,
+			"refresh_token": o.refreshToken,
+		})
+		// the refresh token may be expired, try the grant type of config
+		var oauthErr *OAuth2Error
+		if err != nil && errors.As(err, &oauthErr) && o.config.GrantType != GrantRefreshToken {
+			o.refreshToken = ""
+			token, err = nil, nil
+		}
+	}
+
+	if token == nil && err == nil {
+		switch o.config.GrantType {
+		case GrantClientCredentials:
+			token, err = o.request(ctx, map[string]string{"grant_type": GrantClientCredentials})
+		case GrantPassword:
+			token, err = o.request(ctx, map[string]string{
+				"grant_type": GrantPassword,
+				"username":   o.config.Username,
+				"password":   o.config.Password,
+			})
+		case GrantRefreshToken:
+			err = errors.New("zhttp: oauth2 refresh token is empty")
+		default:
+			err = fmt.Errorf("zhttp: unsupported oauth2 grant type %q", o.config.GrantType)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.token = token
+	if token.RefreshToken != "" {
+		o.refreshToken = token.RefreshToken
+	}
+
+	return token, nil
+}
+
+// request send the token request with params
+func (o *OAuth2) request(ctx context.Context, params map[string]string) (*OAuth2Token, error) {
+	if len(o.config.Scopes) > 0 {
+		params["scope"] = strings.Join(o.config.Scopes, " ")
+	}
+	for k, v := range o.config.EndpointParams {
+		params[k] = v
+	}
+
+	options := &ReqOptions{
+		Headers:       map[string]string{"Accept": "application/json"},
+		Authenticator: noAuth{},
+	}
+	if o.config.ClientAuthInBody {
+		params["client_id"] = o.config.ClientID
+		if o.config.ClientSecret != "" {
+			params["client_secret"] = o.config.ClientSecret
+		}
+	} else if o.config.ClientID != "" {
+		options.Authenticator = Basic(o.config.ClientID, o.config.ClientSecret)
+	}
+	options.Body = Form(params)
+
+	resp, err := o.z.PostContext(ctx, o.config.TokenURL, options)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	var result struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		RefreshToken     string          `json:"refresh_token"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+
+	err = resp.JSON(&result)
+	if !resp.OK() || result.Error != "" {
+		return nil, &OAuth2Error{
+			StatusCode:  resp.StatusCode,
+			Code:        result.Error,
+			Description: result.ErrorDescription,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("zhttp: oauth2 token response has no access_token")
+	}
+
+	token := &OAuth2Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+	}
+
+	// expires_in may be a number or a string
+	seconds, _ := strconv.ParseInt(strings.Trim(string(result.ExpiresIn), `"`), 10, 64)
+	if seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+
+	return token, nil
+}
+
+// noAuth is used to prevent the request from being authenticated by the authenticator of Zhttp
+type noAuth struct{}
+
+func (noAuth) Authenticate(req *http.Request) error {
+	return nil
+}
+
+// valuelessContext keep the deadline and cancellation of the parent but not the values
+type valuelessContext struct {
+	context.Context
+}
+
+func (valuelessContext) Value(key interface{}) interface{} {
+	return nil
+}
@@ -0,0 +1,245 @@
+package zhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// oauth2TestServer is a stub of the token endpoint at /token and the api at /api
+type oauth2TestServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	forms     []map[string]string
+	issued    int
+	expiresIn int
+	apiCalls  int
+
+	// accepted report whether the api accepts the access token
+	accepted func(token string) bool
+}
+
+func newOAuth2TestServer(t *testing.T) *oauth2TestServer {
+	s := &oauth2TestServer{
+		t:         t,
+		expiresIn: 3600,
+		accepted:  func(string) bool { return true },
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			t.Error(err)
+		}
+		form := map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		if user, pass, ok := r.BasicAuth(); ok {
+			form["basic"] = user + ":" + pass
+		}
+
+		s.mu.Lock()
+		s.forms = append(s.forms, form)
+		s.issued++
+		n := s.issued
+		expiresIn := s.expiresIn
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access%d", n),
+			"token_type":    "Bearer",
+			"refresh_token": fmt.Sprintf("refresh%d", n),
+			"expires_in":    expiresIn,
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.apiCalls++
+		accepted := s.accepted
+		s.mu.Unlock()
+
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || auth[:7] != "Bearer " || !accepted(auth[7:]) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(auth[7:]))
+	})
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+func (s *oauth2TestServer) tokenForms() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.forms...)
+}
+
+func (s *oauth2TestServer) get(z *Zhttp, auth Authenticator) *Response {
+	resp, err := z.Get(s.URL+"/api", &ReqOptions{Authenticator: auth})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	// read the body, which is cached for the checks
+	resp.Body.Bytes()
+	return resp
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+
+	z := New(nil)
+	o := z.NewOAuth2(&OAuth2Config{
+		TokenURL:     s.URL + "/token",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+
+	for i := 0; i < 2; i++ {
+		resp := s.get(z, o)
+		if resp.StatusCode != http.StatusOK || resp.Body.String() != "access1" {
+			t.Fatalf("got %d %q, want 200 access1", resp.StatusCode, resp.Body.String())
+		}
+	}
+
+	forms := s.tokenForms()
+	if len(forms) != 1 {
+		t.Fatalf("token is requested %d times, want 1", len(forms))
+	}
+	if forms[0]["grant_type"] != GrantClientCredentials || forms[0]["scope"] != "read write" || forms[0]["basic"] != "client:secret" {
+		t.Errorf("invalid token request %v", forms[0])
+	}
+}
+
+func TestOAuth2ClientAuthInBody(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+
+	z := New(nil)
+	o := z.NewOAuth2(&OAuth2Config{
+		TokenURL:         s.URL + "/token",
+		ClientID:         "client",
+		ClientSecret:     "secret",
+		ClientAuthInBody: true,
+	})
+	s.get(z, o)
+
+	forms := s.tokenForms()
+	if len(forms) != 1 || forms[0]["client_id"] != "client" || forms[0]["client_secret"] != "secret" || forms[0]["basic"] != "" {
+		t.Errorf("invalid token requests %v", forms)
+	}
+}
+
+func TestOAuth2RefreshToken(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+
+	z := New(nil)
+	o := z.NewOAuth2(&OAuth2Config{
+		TokenURL:     s.URL + "/token",
+		GrantType:    GrantRefreshToken,
+		RefreshToken: "initial",
+	})
+
+	s.get(z, o)
+
+	// expire the token, the refresh token returned by server should be used
+	o.SetToken(&OAuth2Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Second)})
+	resp := s.get(z, o)
+	if resp.Body.String() != "access2" {
+		t.Errorf("got token %q, want access2", resp.Body.String())
+	}
+
+	forms := s.tokenForms()
+	if len(forms) != 2 {
+		t.Fatalf("token is requested %d times, want 2", len(forms))
+	}
+	for i, want := range []string{"initial", "refresh1"} {
+		if forms[i]["grant_type"] != GrantRefreshToken || forms[i]["refresh_token"] != want {
+			t.Errorf("token request %d is %v, want refresh token %s", i, forms[i], want)
+		}
+	}
+}
+
+func TestOAuth2ExpiryDelta(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+	s.expiresIn = 60
+
+	z := New(nil)
+
+	// the token expires within ExpiryDelta, it is refreshed for every request
+	o := z.NewOAuth2(&OAuth2Config{TokenURL: s.URL + "/token", ExpiryDelta: 2 * time.Minute})
+	for i := 1; i <= 2; i++ {
+		resp := s.get(z, o)
+		if want := fmt.Sprintf("access%d", i); resp.Body.String() != want {
+			t.Errorf("got token %q, want %s", resp.Body.String(), want)
+		}
+	}
+	forms := s.tokenForms()
+	if len(forms) != 2 || forms[1]["grant_type"] != GrantRefreshToken || forms[1]["refresh_token"] != "refresh1" {
+		t.Fatalf("invalid token requests %v", forms)
+	}
+
+	// the default ExpiryDelta is shorter than the lifetime of token
+	o = z.NewOAuth2(&OAuth2Config{TokenURL: s.URL + "/token"})
+	s.get(z, o)
+	s.get(z, o)
+	if n := len(s.tokenForms()); n != 3 {
+		t.Errorf("token is requested %d times, want 3", n)
+	}
+}
+
+func TestOAuth2RetryOnUnauthorized(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+	// the first token is revoked
+	s.accepted = func(token string) bool { return token != "access1" }
+
+	z := New(nil)
+	o := z.NewOAuth2(&OAuth2Config{TokenURL: s.URL + "/token"})
+
+	resp := s.get(z, o)
+	if resp.StatusCode != http.StatusOK || resp.Body.String() != "access2" {
+		t.Fatalf("got %d %q, want 200 access2", resp.StatusCode, resp.Body.String())
+	}
+	if s.apiCalls != 2 {
+		t.Errorf("api is called %d times, want 2", s.apiCalls)
+	}
+	if n := len(s.tokenForms()); n != 2 {
+		t.Errorf("token is requested %d times, want 2", n)
+	}
+}
+
+func TestOAuth2RetryOnce(t *testing.T) {
+	s := newOAuth2TestServer(t)
+	defer s.Close()
+	// all tokens are rejected
+	s.accepted = func(string) bool { return false }
+
+	z := New(nil)
+	o := z.NewOAuth2(&OAuth2Config{TokenURL: s.URL + "/token"})
+
+	resp := s.get(z, o)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want 401", resp.StatusCode)
+	}
+	if s.apiCalls != 2 {
+		t.Errorf("api is called %d times, want 2", s.apiCalls)
+	}
+	if n := len(s.tokenForms()); n != 2 {
+		t.Errorf("token is requested %d times, want 2", n)
+	}
+}
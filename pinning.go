@@ -0,0 +1,151 @@
+package zhttp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PinError is returned when the certificates of server do not match the pins of host
+type PinError struct {
+	// Host is the server name of the tls connection
+	Host string
+
+	// Hashes is the base64 SPKI SHA-256 of the certificates checked
+	Hashes []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("zhttp: certificate pin mismatch for %s, got %s", e.Host, strings.Join(e.Hashes, ", "))
+}
+
+// SPKIHash return the base64 SHA-256 of the SubjectPublicKeyInfo of cert,
+// which is the format of HTTPOptions.Pins
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type pinRule struct {
+	pattern string
+	hashes  map[string]bool
+}
+
+// pinVerifier check the certificates of server by the pins
+type pinVerifier struct {
+	rules []*pinRule
+}
+
+func newPinVerifier(pins map[string][]string) (*pinVerifier, error) {
+	v := &pinVerifier{}
+
+	for pattern, hashes := range pins {
+		rule := &pinRule{
+			pattern: strings.TrimSuffix(strings.ToLower(pattern), "."),
+			hashes:  map[string]bool{},
+		}
+
+		for _, h := range hashes {
+			h = strings.TrimPrefix(h, "sha256/")
+			data, err := base64.StdEncoding.DecodeString(h)
+			if err != nil || len(data) != sha256.Size {
+				return nil, fmt.Errorf("zhttp: invalid pin %q of %s", h, pattern)
+			}
+			rule.hashes[h] = true
+		}
+
+		v.rules = append(v.rules, rule)
+	}
+
+	return v, nil
+}
+
+// match return the rule of host, the exact host is preferred over the wildcard
+func (v *pinVerifier) match(host string) *pinRule {
+	var matched *pinRule
+
+	for _, rule := range v.rules {
+		switch {
+		case rule.pattern == host:
+			return rule
+		case rule.pattern == "*":
+		case strings.HasPrefix(rule.pattern, "*.") && strings.HasSuffix(host, rule.pattern[1:]):
+		default:
+			continue
+		}
+
+		// the longer wildcard is more specific
+		if matched == nil || len(rule.pattern) > len(matched.pattern) {
+			matched = rule
+		}
+	}
+
+	return matched
+}
+
+// verifyConnection is used as tls.Config.VerifyConnection, the host is the server name of tls.
+// The server name is empty when the host is an ip, such connections are verified by verifyHost
+func (v *pinVerifier) verifyConnection(cs tls.ConnectionState) error {
+	return v.verifyHost(cs.ServerName, cs)
+}
+
+// verifyHost check the certificates of the connection by the pins of host.
+// The certificates of the verified chains are checked, any of them matches the pins is accepted.
+// When the verification is skipped by InsecureSkipVerify, only the leaf certificate is checked
+func (v *pinVerifier) verifyHost(host string, cs tls.ConnectionState) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return nil
+	}
+
+	rule := v.match(host)
+	if rule == nil {
+		return nil
+	}
+
+	var certs []*x509.Certificate
+	if len(cs.VerifiedChains) > 0 {
+		for _, chain := range cs.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+	} else if len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+
+	var hashes []string
+	for _, cert := range certs {
+		h := SPKIHash(cert)
+		if rule.hashes[h] {
+			return nil
+		}
+		hashes = append(hashes, h)
+	}
+
+	return &PinError{Host: host, Hashes: hashes}
+}
+
+// boundHost return the host whose pins should be bound to the connection by bindHost.
+// The pins are matched on the host of url, which can not be done by the server name of tls if the host is an ip,
+// whose server name is empty, or the server name is overridden by ReqOptions.ServerName.
+// Empty is returned if the host is not pinned or the server name is enough
+func (v *pinVerifier) boundHost(host, serverName string) string {
+	if v == nil || serverName == host && net.ParseIP(host) == nil {
+		return ""
+	}
+	if v.match(strings.TrimSuffix(strings.ToLower(host), ".")) == nil {
+		return ""
+	}
+
+	return host
+}
+
+// bindHost return the VerifyConnection function which checks the pins of host instead of the server name of tls
+func (v *pinVerifier) bindHost(host string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		return v.verifyHost(host, cs)
+	}
+}
@@ -0,0 +1,181 @@
+package zhttp
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// wrongPin is a valid pin which matches no certificate
+const wrongPin = "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func TestPinVerifierMatch(t *testing.T) {
+	v, err := newPinVerifier(map[string][]string{
+		"api.example.com":    {wrongPin},
+		"*.example.com":      {wrongPin},
+		"*.test.example.com": {wrongPin},
+		"*":                  {wrongPin},
+		"Upper.Example.Org.": {wrongPin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host    string
+		pattern string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"www.example.com", "*.example.com"},
+		{"a.test.example.com", "*.test.example.com"},
+		{"example.com", "*"},
+		{"upper.example.org", "upper.example.org"},
+		{"other.org", "*"},
+	}
+
+	for _, tt := range tests {
+		rule := v.match(tt.host)
+		if rule == nil || rule.pattern != tt.pattern {
+			t.Errorf("match(%s) = %+v, want %s", tt.host, rule, tt.pattern)
+		}
+	}
+
+	_, err = newPinVerifier(map[string][]string{"example.com": {"sha256/invalid"}})
+	if err == nil {
+		t.Error("invalid pin is accepted")
+	}
+}
+
+func TestPins(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// the handshake errors of the mismatched pins are expected
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	pin := "sha256/" + SPKIHash(ts.Certificate())
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	u, _ := url.Parse(ts.URL)
+	port := u.Port()
+
+	tests := []struct {
+		name       string
+		pins       map[string][]string
+		insecure   bool
+		url        string
+		hostIP     string
+		serverName string
+		mismatch   string
+	}{
+		{
+			name:   "matching pin",
+			pins:   map[string][]string{"example.com": {wrongPin, pin}},
+			url:    "https://example.com:" + port,
+			hostIP: "127.0.0.1",
+		},
+		{
+			name:     "mismatched pin",
+			pins:     map[string][]string{"example.com": {wrongPin}},
+			url:      "https://example.com:" + port,
+			hostIP:   "127.0.0.1",
+			mismatch: "example.com",
+		},
+		{
+			name:   "unpinned host",
+			pins:   map[string][]string{"other.com": {wrongPin}},
+			url:    "https://example.com:" + port,
+			hostIP: "127.0.0.1",
+		},
+		{
+			name:     "wildcard host",
+			pins:     map[string][]string{"*.example.com": {pin}},
+			insecure: true,
+			url:      "https://www.example.com:" + port,
+			hostIP:   "127.0.0.1",
+		},
+		{
+			name:     "mismatched wildcard host",
+			pins:     map[string][]string{"*.example.com": {wrongPin}},
+			insecure: true,
+			url:      "https://www.example.com:" + port,
+			hostIP:   "127.0.0.1",
+			mismatch: "www.example.com",
+		},
+		{
+			name:     "exact host over wildcard",
+			pins:     map[string][]string{"www.example.com": {pin}, "*.example.com": {wrongPin}},
+			insecure: true,
+			url:      "https://www.example.com:" + port,
+			hostIP:   "127.0.0.1",
+		},
+		{
+			name: "ip host",
+			pins: map[string][]string{"127.0.0.1": {pin}},
+			url:  ts.URL,
+		},
+		{
+			name:     "mismatched ip host",
+			pins:     map[string][]string{"127.0.0.1": {wrongPin}},
+			url:      ts.URL,
+			mismatch: "127.0.0.1",
+		},
+		{
+			name:       "url host over server name",
+			pins:       map[string][]string{"127.0.0.1": {pin}, "example.com": {wrongPin}},
+			url:        ts.URL,
+			serverName: "example.com",
+		},
+		{
+			name:       "mismatched url host with server name",
+			pins:       map[string][]string{"127.0.0.1": {wrongPin}, "example.com": {pin}},
+			url:        ts.URL,
+			serverName: "example.com",
+			mismatch:   "127.0.0.1",
+		},
+		{
+			name:       "server name of unpinned url host",
+			pins:       map[string][]string{"example.com": {wrongPin}},
+			url:        ts.URL,
+			serverName: "example.com",
+			mismatch:   "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := New(&HTTPOptions{
+				RootCAs:            roots,
+				InsecureSkipVerify: tt.insecure,
+				Pins:               tt.pins,
+			})
+
+			resp, err := z.Get(tt.url, &ReqOptions{HostIP: tt.hostIP, ServerName: tt.serverName})
+			if tt.mismatch == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Close()
+				return
+			}
+
+			if err == nil {
+				resp.Close()
+				t.Fatal("got no error")
+			}
+			var pinErr *PinError
+			if !errors.As(err, &pinErr) {
+				t.Fatalf("got error %v, want *PinError", err)
+			}
+			if pinErr.Host != tt.mismatch || len(pinErr.Hashes) == 0 || pinErr.Hashes[0] != SPKIHash(ts.Certificate()) {
+				t.Errorf("got %+v", pinErr)
+			}
+		})
+	}
+}
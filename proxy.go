@@ -0,0 +1,511 @@
+package zhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// roundTripper route the requests to the transports.
+// The requests through socks proxy are sent by the transport which dials through the proxy,
+// so that the connections of different proxies will not be mixed in the connection pool.
+// The requests with HostIP are sent by the transport which connects to the ip, through http proxy
+// the connection is tunneled by CONNECT to the ip, so that the proxy will not resolve the host.
+// The requests to the pinned hosts which can not be checked by the server name of tls, such as the ip hosts,
+// are sent by the transport which checks the pins of the host.
+// The requests with the tls settings of ReqOptions are sent by the transport of the settings
+type roundTripper struct {
+	options  *HTTPOptions
+	base     *http.Transport
+	dialer   *net.Dialer
+	resolver Resolver
+	pins     *pinVerifier
+
+	mu         sync.Mutex
+	transports map[transportKey]*transportEntry
+	// used is the sequence of the last use of transports
+	used uint64
+}
+
+// transportEntry is a transport derived from base
+type transportEntry struct {
+	transport *http.Transport
+
+	// active is the number of the round trips whose responses are not finished,
+	// the transport is not evicted while it is in use
+	active int
+
+	// used is the sequence of the last use, the least recently used transport is evicted
+	used uint64
+}
+
+func newRoundTripper(z *Zhttp) *roundTripper {
+	return &roundTripper{
+		options:    z.options,
+		base:       z.transport,
+		dialer:     z.dialer,
+		resolver:   z.resolver,
+		pins:       z.pins,
+		transports: map[transportKey]*transportEntry{},
+	}
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the timings of a redirect are recorded in a new hop,
+	// the round trips of authentication challenges below are in the same hop
+	if req.Response != nil {
+		if recorder, ok := req.Context().Value(ctxTimingKey).(*timingRecorder); ok {
+			recorder.redirect()
+		}
+	}
+
+	choice, err := selectProxy(rt.options, req, true)
+	if err != nil {
+		return nil, err
+	}
+	// the proxy is selected once for each round trip,
+	// so that the transport uses the same proxy picked from the pool
+	req = req.WithContext(context.WithValue(req.Context(), ctxProxyChoiceKey, choice))
+
+	reqOptions, _ := req.Context().Value(ctxOptionKey).(*ReqOptions)
+
+	var key transportKey
+	if choice.url != nil && isSocksProxy(choice.url) {
+		key.socks = choice.url.String()
+	}
+	if reqOptions != nil && reqOptions.HostIP != "" {
+		key.hostIP = reqOptions.HostIP
+		if choice.url != nil && key.socks == "" {
+			key.tunnel = choice.url.String()
+		}
+	}
+	if req.URL.Scheme == "https" {
+		host := req.URL.Hostname()
+		serverName := host
+		if reqOptions != nil {
+			key.tls = newTLSKey(reqOptions)
+			if reqOptions.ServerName != "" {
+				serverName = reqOptions.ServerName
+			}
+		}
+		key.pinHost = rt.pins.boundHost(host, serverName)
+	}
+	transport, done := rt.transport(key, choice.url, reqOptions)
+	send := transport.RoundTrip
+
+	var resp *http.Response
+	if auth, ok := req.Context().Value(ctxAuthKey).(Authenticator); ok && authAllowed(req) {
+		resp, err = roundTripAuth(req, auth, send)
+	} else {
+		resp, err = send(req)
+	}
+
+	// the transport is in use until the body is read to the end or closed,
+	// the connection has been returned to the idle pool by then
+	if err != nil {
+		done()
+	} else {
+		resp.Body = &releaseReadCloser{ReadCloser: resp.Body, release: done}
+	}
+
+	if choice.pool != nil {
+		if err != nil && isProxyConnectError(err) {
+			choice.pool.markFailed(choice.url)
+		} else if err == nil {
+			choice.pool.markSucceeded(choice.url)
+		}
+	}
+
+	return resp, err
+}
+
+var (
+	ctxProxyChoiceKey  = &struct{ name string }{"proxy choice"}
+	ctxSessionProxyKey = &struct{ name string }{"session proxy"}
+)
+
+// proxyChoice is the proxy selected for a round trip, pool is set if it is picked from a pool
+type proxyChoice struct {
+	url  *url.URL
+	pool *ProxyPool
+}
+
+// selectProxy select the proxy for the request in the following order:
+// ReqOptions.Proxies, ReqOptions.ProxyPool, the proxy pinned by session,
+// HTTPOptions.Proxies, HTTPOptions.ProxyPool, and the proxy from environment.
+// If pick is false, the proxy is not picked from the pool, and the url of the choice is nil
+func selectProxy(options *HTTPOptions, req *http.Request, pick bool) (*proxyChoice, error) {
+	ctx := req.Context()
+
+	reqOptions, ok := ctx.Value(ctxOptionKey).(*ReqOptions)
+	if ok && len(reqOptions.Proxies) > 0 {
+		if p, ok := reqOptions.Proxies[req.URL.Scheme]; ok {
+			return &proxyChoice{url: p}, nil
+		}
+		return proxyFromEnvironment(req)
+	}
+	if ok && reqOptions.ProxyPool != nil {
+		return pickProxy(reqOptions.ProxyPool, pick)
+	}
+
+	if p, ok := ctx.Value(ctxSessionProxyKey).(*pinnedProxy); ok {
+		return &proxyChoice{url: p.url, pool: p.pool}, nil
+	}
+
+	if len(options.Proxies) > 0 {
+		if p, ok := options.Proxies[req.URL.Scheme]; ok {
+			return &proxyChoice{url: p}, nil
+		}
+		return proxyFromEnvironment(req)
+	}
+	if options.ProxyPool != nil {
+		return pickProxy(options.ProxyPool, pick)
+	}
+
+	return proxyFromEnvironment(req)
+}
+
+func pickProxy(pool *ProxyPool, pick bool) (*proxyChoice, error) {
+	if !pick {
+		return &proxyChoice{pool: pool}, nil
+	}
+
+	p, err := pool.Pick()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyChoice{url: p, pool: pool}, nil
+}
+
+func proxyFromEnvironment(req *http.Request) (*proxyChoice, error) {
+	p, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyChoice{url: p}, nil
+}
+
+// CloseIdleConnections close the idle connections of all transports
+func (rt *roundTripper) CloseIdleConnections() {
+	rt.base.CloseIdleConnections()
+
+	rt.mu.Lock()
+	for _, e := range rt.transports {
+		e.transport.CloseIdleConnections()
+	}
+	rt.mu.Unlock()
+}
+
+// transportKey identify the transport derived from base
+type transportKey struct {
+	// socks is the socks proxy which the transport dials through
+	socks string
+
+	// pinHost is the host whose pins are checked by the transport instead of the server name of tls,
+	// such as the ip host whose server name is empty
+	pinHost string
+
+	// tls is the tls settings of ReqOptions
+	tls tlsKey
+
+	// hostIP is ReqOptions.HostIP, the connections to different ips are not shared
+	hostIP string
+
+	// tunnel is the http proxy which the transport tunnels through by CONNECT to hostIP
+	tunnel string
+}
+
+// maxTransports is the max number of transports derived from base, the least recently used transport
+// which is not in use is evicted when it is exceeded, such as the server name of every request is different
+const maxTransports = 256
+
+// transport return the transport of key, it is created from base at the first time.
+// done must be called when the transport is no longer used by the round trip
+func (rt *roundTripper) transport(key transportKey, proxyURL *url.URL, reqOptions *ReqOptions) (*http.Transport, func()) {
+	if key == (transportKey{}) {
+		return rt.base, func() {}
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.used++
+
+	e, ok := rt.transports[key]
+	if !ok {
+		if len(rt.transports) >= maxTransports {
+			rt.evict()
+		}
+		e = &transportEntry{transport: rt.newTransport(key, proxyURL, reqOptions)}
+		rt.transports[key] = e
+	}
+	e.active++
+	e.used = rt.used
+
+	done := func() {
+		rt.mu.Lock()
+		e.active--
+		rt.mu.Unlock()
+	}
+
+	return e.transport, done
+}
+
+// evict remove the least recently used transport which is not in use, and close its idle connections.
+// Nothing is evicted if all transports are in use. It must be called with mu held
+func (rt *roundTripper) evict() {
+	var oldest transportKey
+	var found *transportEntry
+	for k, e := range rt.transports {
+		if e.active == 0 && (found == nil || e.used < found.used) {
+			oldest, found = k, e
+		}
+	}
+
+	if found != nil {
+		found.transport.CloseIdleConnections()
+		delete(rt.transports, oldest)
+	}
+}
+
+// newTransport create the transport of key from base
+func (rt *roundTripper) newTransport(key transportKey, proxyURL *url.URL, reqOptions *ReqOptions) *http.Transport {
+
+	t := rt.base.Clone()
+	if key.socks != "" {
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return rt.dialSocks(ctx, proxyURL, network, addr)
+		}
+	}
+	if key.tunnel != "" {
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return rt.dialTunnel(ctx, proxyURL, net.JoinHostPort(key.hostIP, port))
+		}
+	}
+	if key.tls != (tlsKey{}) {
+		setTLSConfig(t, requestTLSConfig(t.TLSClientConfig, reqOptions))
+	}
+	if key.pinHost != "" {
+		t.TLSClientConfig.VerifyConnection = rt.pins.bindHost(key.pinHost)
+	}
+
+	return t
+}
+
+func isSocksProxy(u *url.URL) bool {
+	switch u.Scheme {
+	case "socks5", "socks5h", "socks4", "socks4a":
+		return true
+	}
+	return false
+}
+
+// dialProxy establish the connection to the proxy server
+func (rt *roundTripper) dialProxy(ctx context.Context, proxyURL *url.URL) (net.Conn, error) {
+	conn, err := dialAddress(ctx, rt.dialer, rt.resolver, rt.options.IPFamily, "tcp", canonicalAddr(proxyURL))
+	if err != nil {
+		return nil, &net.OpError{Op: "proxyconnect", Net: "tcp", Err: err}
+	}
+	return conn, nil
+}
+
+// dialTunnel establish the tunnel to addr through http or https proxy by CONNECT
+func (rt *roundTripper) dialTunnel(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := rt.dialProxy(ctx, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		conn, err = rt.tlsClient(ctx, conn, proxyURL.Hostname(), nil)
+		if err != nil {
+			return nil, &net.OpError{Op: "proxyconnect", Net: "tcp", Err: err}
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	err = proxyConnect(conn, proxyURL, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// tlsClient establish the tls connection to host over conn with the tls config of base,
+// the tls settings of options are applied if it is not nil, host is the server name if options does not set it.
+// The connection is used as HTTP/1.x, such as the raw request and the connection to https proxy
+func (rt *roundTripper) tlsClient(ctx context.Context, conn net.Conn, host string, options *ReqOptions) (net.Conn, error) {
+	config := requestTLSConfig(rt.base.TLSClientConfig, options)
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if pinHost := rt.pins.boundHost(host, config.ServerName); pinHost != "" {
+		config.VerifyConnection = rt.pins.bindHost(pinHost)
+	}
+	config.NextProtos = []string{"http/1.1"}
+
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// dialSocks establish the connection to addr through socks proxy.
+// For socks5 and socks4 the host is resolved locally, by the resolver of HTTPOptions if it is set.
+// For socks5h and socks4a the host is resolved by the proxy.
+// If ReqOptions.HostIP is set, it is used as the destination
+func (rt *roundTripper) dialSocks(ctx context.Context, proxyURL *url.URL, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reqOptions, ok := ctx.Value(ctxOptionKey).(*ReqOptions)
+	if ok && reqOptions.HostIP != "" {
+		host = reqOptions.HostIP
+	} else if net.ParseIP(host) == nil && (proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks4") {
+		ip, err := rt.lookup(ctx, host, proxyURL.Scheme == "socks4")
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+
+	switch proxyURL.Scheme {
+	case "socks4", "socks4a":
+		conn, err := rt.dialProxy(ctx, proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		err = socks4Handshake(ctx, conn, proxyURL, host, port)
+		if err != nil {
+			conn.Close()
+			return nil, &net.OpError{Op: "proxyconnect", Net: "tcp", Err: err}
+		}
+		return conn, nil
+	default:
+		var auth *proxy.Auth
+		if u := proxyURL.User; u != nil {
+			auth = &proxy.Auth{User: u.Username()}
+			auth.Password, _ = u.Password()
+		}
+		forward := contextDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+			return rt.dialProxy(ctx, proxyURL)
+		})
+		dialer, err := proxy.SOCKS5("tcp", canonicalAddr(proxyURL), auth, forward)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, network, net.JoinHostPort(host, port))
+	}
+}
+
+// lookup resolve the host locally, by the resolver of HTTPOptions if it is set.
+// The ip of HTTPOptions.IPFamily is returned, or the ipv4 address if v4 is true
+func (rt *roundTripper) lookup(ctx context.Context, host string, v4 bool) (net.IP, error) {
+	family := rt.options.IPFamily
+	if v4 {
+		family = IPv4Only
+	}
+
+	ips, err := lookupIPs(ctx, rt.resolver, host, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips[0], nil
+}
+
+// contextDialer adapts a dial function to proxy.Dialer and proxy.ContextDialer
+type contextDialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (d contextDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
+
+// socks4Handshake send the CONNECT command of socks4 or socks4a,
+// the host must be an ipv4 address for socks4
+func socks4Handshake(ctx context.Context, conn net.Conn, proxyURL *url.URL, host, port string) error {
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks4: invalid port %q", port)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := []byte{4, 1, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(portNum))
+
+	ip := net.ParseIP(host)
+	if ip != nil {
+		ip = ip.To4()
+		if ip == nil {
+			return errors.New("socks4: ipv6 address is not supported")
+		}
+		req = append(req, ip...)
+	} else {
+		// socks4a, the invalid ip 0.0.0.x means the host name follows the user id
+		req = append(req, 0, 0, 0, 1)
+	}
+
+	if u := proxyURL.User; u != nil {
+		req = append(req, u.Username()...)
+	}
+	req = append(req, 0)
+
+	if ip == nil {
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 8)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp[1] != 0x5a {
+		return fmt.Errorf("socks4: request rejected with code %d", resp[1])
+	}
+
+	return nil
+}
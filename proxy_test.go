@@ -0,0 +1,46 @@
+package zhttp
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTransportEviction(t *testing.T) {
+	rt := New(nil).roundTripper
+
+	keys := make([]transportKey, maxTransports)
+	dones := make([]func(), maxTransports)
+	for i := range keys {
+		keys[i] = transportKey{hostIP: fmt.Sprintf("10.0.%d.%d", i/256, i%256)}
+		_, dones[i] = rt.transport(keys[i], nil, nil)
+	}
+
+	// the first transport is still in use, the second one is used again
+	for _, done := range dones[1:] {
+		done()
+	}
+	_, done := rt.transport(keys[1], nil, nil)
+	done()
+
+	_, done = rt.transport(transportKey{hostIP: "10.1.0.0"}, nil, nil)
+	done()
+
+	if len(rt.transports) != maxTransports {
+		t.Fatalf("got %d transports, want %d", len(rt.transports), maxTransports)
+	}
+	for i, key := range keys[:3] {
+		_, ok := rt.transports[key]
+		if want := i != 2; ok != want {
+			t.Errorf("transport %d exists: %v, want %v", i, ok, want)
+		}
+	}
+
+	// nothing is evicted if all transports are in use
+	for _, e := range rt.transports {
+		e.active++
+	}
+	rt.transport(transportKey{hostIP: "10.1.0.1"}, nil, nil)
+	if len(rt.transports) != maxTransports+1 {
+		t.Errorf("got %d transports, want %d", len(rt.transports), maxTransports+1)
+	}
+}
@@ -0,0 +1,304 @@
+package zhttp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrNoProxyAvailable is returned when all proxies of the pool are ejected
+var ErrNoProxyAvailable = errors.New("zhttp: no proxy available in the pool")
+
+// ProxyStrategy is the way to select a proxy from the pool
+type ProxyStrategy int
+
+const (
+	// RoundRobin select the proxies in turn
+	RoundRobin ProxyStrategy = iota
+	// RandomProxy select a proxy randomly
+	RandomProxy
+	// LeastUsed select the proxy which has been selected the fewest times
+	LeastUsed
+)
+
+// ProxyPoolOptions is the options of ProxyPool
+type ProxyPoolOptions struct {
+	// Strategy is the way to select a proxy, default is RoundRobin
+	Strategy ProxyStrategy
+
+	// MaxFails is the number of consecutive connect failures before the proxy is ejected,
+	// default is 3
+	MaxFails int
+
+	// HealthCheckInterval is the interval of probing the ejected proxies,
+	// the proxy is put back to the pool once it can be connected. Default is 30 seconds
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the timeout of connecting to the proxy when probing, default is 5 seconds
+	HealthCheckTimeout time.Duration
+}
+
+// ProxyPool is a set of proxies used in turn.
+// The proxy which fails to connect will be ejected, and put back after it is probed to be alive.
+// It is safe for concurrent use
+type ProxyPool struct {
+	options ProxyPoolOptions
+
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+	rand    *rand.Rand
+
+	checkOnce sync.Once
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type poolProxy struct {
+	url     *url.URL
+	fails   int
+	ejected bool
+	used    int64
+}
+
+// NewProxyPool create a pool with the proxies, the options can be nil.
+// The proxy can be http, https, socks5, socks5h, socks4 and socks4a.
+// Close should be called to stop the health check when the pool is no longer used
+func NewProxyPool(proxies []*url.URL, options *ProxyPoolOptions) *ProxyPool {
+	p := &ProxyPool{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop: make(chan struct{}),
+	}
+
+	if options != nil {
+		p.options = *options
+	}
+	if p.options.MaxFails <= 0 {
+		p.options.MaxFails = 3
+	}
+	if p.options.HealthCheckInterval <= 0 {
+		p.options.HealthCheckInterval = 30 * time.Second
+	}
+	if p.options.HealthCheckTimeout <= 0 {
+		p.options.HealthCheckTimeout = 5 * time.Second
+	}
+
+	for _, u := range proxies {
+		p.Add(u)
+	}
+
+	return p
+}
+
+// Add add a proxy to the pool, the proxy already in the pool is ignored
+func (p *ProxyPool) Add(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.find(u) != nil {
+		return
+	}
+	p.proxies = append(p.proxies, &poolProxy{url: u})
+}
+
+// Remove remove a proxy from the pool
+func (p *ProxyPool) Remove(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, pp := range p.proxies {
+		if pp.url.String() == u.String() {
+			p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+			return
+		}
+	}
+}
+
+// Proxies return all proxies in the pool, including the ejected ones
+func (p *ProxyPool) Proxies() []*url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]*url.URL, len(p.proxies))
+	for i, pp := range p.proxies {
+		list[i] = pp.url
+	}
+	return list
+}
+
+// Available return the proxies which are not ejected
+func (p *ProxyPool) Available() []*url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var list []*url.URL
+	for _, pp := range p.proxies {
+		if !pp.ejected {
+			list = append(list, pp.url)
+		}
+	}
+	return list
+}
+
+// Pick select a proxy by the strategy, ErrNoProxyAvailable is returned if all proxies are ejected
+func (p *ProxyPool) Pick() (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var selected *poolProxy
+
+	switch p.options.Strategy {
+	case RandomProxy:
+		var alive []*poolProxy
+		for _, pp := range p.proxies {
+			if !pp.ejected {
+				alive = append(alive, pp)
+			}
+		}
+		if len(alive) > 0 {
+			selected = alive[p.rand.Intn(len(alive))]
+		}
+	case LeastUsed:
+		for _, pp := range p.proxies {
+			if !pp.ejected && (selected == nil || pp.used < selected.used) {
+				selected = pp
+			}
+		}
+	default:
+		for i := 0; i < len(p.proxies); i++ {
+			pp := p.proxies[(p.next+i)%len(p.proxies)]
+			if !pp.ejected {
+				selected = pp
+				p.next = (p.next + i + 1) % len(p.proxies)
+				break
+			}
+		}
+	}
+
+	if selected == nil {
+		return nil, ErrNoProxyAvailable
+	}
+
+	selected.used++
+	return selected.url, nil
+}
+
+// Close stop the health check of the pool
+func (p *ProxyPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *ProxyPool) find(u *url.URL) *poolProxy {
+	key := u.String()
+	for _, pp := range p.proxies {
+		if pp.url == u || pp.url.String() == key {
+			return pp
+		}
+	}
+	return nil
+}
+
+// markFailed record a connect failure of the proxy, and eject it if it fails too many times
+func (p *ProxyPool) markFailed(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pp := p.find(u)
+	if pp == nil || pp.ejected {
+		return
+	}
+
+	pp.fails++
+	if pp.fails >= p.options.MaxFails {
+		pp.ejected = true
+		p.checkOnce.Do(func() {
+			go p.healthCheck()
+		})
+	}
+}
+
+// markSucceeded reset the failures of the proxy
+func (p *ProxyPool) markSucceeded(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pp := p.find(u)
+	if pp != nil {
+		pp.fails = 0
+	}
+}
+
+// healthCheck probe the ejected proxies periodically until the pool is closed
+func (p *ProxyPool) healthCheck() {
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		var ejected []*url.URL
+		for _, pp := range p.proxies {
+			if pp.ejected {
+				ejected = append(ejected, pp.url)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, u := range ejected {
+			if p.probe(u) {
+				p.mu.Lock()
+				if pp := p.find(u); pp != nil {
+					pp.ejected = false
+					pp.fails = 0
+				}
+				p.mu.Unlock()
+			}
+		}
+	}
+}
+
+// probe check whether the proxy can be connected
+func (p *ProxyPool) probe(u *url.URL) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.options.HealthCheckTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", canonicalAddr(u))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// isProxyConnectError report whether the error is caused by failing to connect to the proxy.
+// The whole chain is checked, as the socks5 dialer wraps the error by its own *net.OpError
+func isProxyConnectError(err error) bool {
+	for err != nil {
+		if opErr, ok := err.(*net.OpError); ok && opErr.Op == "proxyconnect" {
+			return true
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package zhttp
+
+import (
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestProxyPoolEjection(t *testing.T) {
+	// the address which refuses the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	for _, scheme := range []string{"http", "https", "socks4", "socks4a", "socks5", "socks5h"} {
+		t.Run(scheme, func(t *testing.T) {
+			u, _ := url.Parse(scheme + "://" + addr)
+			pool := NewProxyPool([]*url.URL{u}, &ProxyPoolOptions{MaxFails: 1})
+			defer pool.Close()
+
+			z := New(&HTTPOptions{ProxyPool: pool})
+			_, err := z.Get("http://127.0.0.1:1/", nil)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !isProxyConnectError(err) {
+				t.Errorf("got error %v, want proxy connect error", err)
+			}
+
+			if list := pool.Available(); len(list) != 0 {
+				t.Errorf("proxy is not ejected, available %v", list)
+			}
+			_, err = pool.Pick()
+			if err != ErrNoProxyAvailable {
+				t.Errorf("got error %v, want %v", err, ErrNoProxyAvailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package zhttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when RateLimit.FailFast is set and the request exceeds the rate limit
+var ErrRateLimited = errors.New("zhttp: rate limit exceeded")
+
+// RateLimit is the token bucket rate limiting of requests.
+// The host of a request is ReqOptions.HostIP, ReqOptions.Host or the host of url in order,
+// so the requests sent to different ips of a host name are limited separately.
+// Every attempt of a retried request takes a token, redirects do not
+type RateLimit struct {
+	// Rate is the number of requests per second for all hosts, zero means unlimited
+	Rate float64
+
+	// Burst is the max number of requests sent at once for all hosts, default is 1
+	Burst int
+
+	// HostRate is the number of requests per second for each host, zero means unlimited
+	HostRate float64
+
+	// HostBurst is the max number of requests sent at once for each host, default is 1
+	HostBurst int
+
+	// Hosts is the number of requests per second for the specified hosts,
+	// overwrite HostRate for these hosts. The burst is HostBurst
+	Hosts map[string]float64
+
+	// FailFast will return ErrRateLimited instead of waiting for the token
+	FailFast bool
+}
+
+// tokenBucket is a token bucket which allows burst requests at once and refills rate tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// latest is the time when the last reserved token is available
+	latest time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill add the tokens generated since last time, must be called with mu held
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// reserve take a token, and return the duration to wait until the token is available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	if at := now.Add(delay); at.After(b.latest) {
+		b.latest = at
+	}
+
+	return delay
+}
+
+// cancel give back the token reserved to be available at at, when the waiting is cancelled before it.
+// The token which is already available is not given back, and the part reserved by the later waitings
+// is kept, so that they are not allowed earlier than the rate
+func (b *tokenBucket) cancel(now, at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !at.After(now) {
+		return
+	}
+
+	restore := 1 - b.latest.Sub(at).Seconds()*b.rate
+	if restore <= 0 {
+		return
+	}
+
+	b.refill(now)
+	b.tokens += restore
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	if b.latest.Equal(at) {
+		b.latest = now
+	}
+}
+
+// allow take a token if it is available now
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// release give back a token which is taken but not used
+func (b *tokenBucket) release() {
+	b.mu.Lock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// idle report whether the bucket is full, which is the same as a new one
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// rateLimiter is shared by the Zhttp and all sessions created from it
+type rateLimiter struct {
+	options *RateLimit
+	global  *tokenBucket
+	rates   map[string]float64
+
+	mu      sync.Mutex
+	hosts   map[string]*tokenBucket
+	sweepAt int
+}
+
+func newRateLimiter(options *RateLimit) *rateLimiter {
+	if options == nil {
+		return nil
+	}
+
+	l := &rateLimiter{
+		options: options,
+		rates:   make(map[string]float64, len(options.Hosts)),
+		hosts:   map[string]*tokenBucket{},
+		sweepAt: 1024,
+	}
+
+	if options.Rate > 0 {
+		l.global = newTokenBucket(options.Rate, options.Burst)
+	}
+	for host, rate := range options.Hosts {
+		l.rates[strings.ToLower(host)] = rate
+	}
+
+	return l
+}
+
+// hostBucket return the bucket of host, nil if the host is unlimited
+func (l *rateLimiter) hostBucket(host string) *tokenBucket {
+	rate, ok := l.rates[host]
+	if !ok {
+		rate = l.options.HostRate
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.hosts[host]
+	if ok {
+		return b
+	}
+
+	// remove the full buckets, so that the map does not grow with the hosts requested only once
+	if len(l.hosts) >= l.sweepAt {
+		now := time.Now()
+		for h, b := range l.hosts {
+			if b.idle(now) {
+				delete(l.hosts, h)
+			}
+		}
+		l.sweepAt = 2 * len(l.hosts)
+		if l.sweepAt < 1024 {
+			l.sweepAt = 1024
+		}
+	}
+
+	b = newTokenBucket(rate, l.options.HostBurst)
+	l.hosts[host] = b
+
+	return b
+}
+
+// wait block until the request to host is allowed or ctx is done
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+	var buckets []*tokenBucket
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+	if b := l.hostBucket(strings.ToLower(host)); b != nil {
+		buckets = append(buckets, b)
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	if l.options.FailFast {
+		for i, b := range buckets {
+			if !b.allow(now) {
+				for _, taken := range buckets[:i] {
+					taken.release()
+				}
+				return ErrRateLimited
+			}
+		}
+		return nil
+	}
+
+	var delay time.Duration
+	delays := make([]time.Duration, len(buckets))
+	for i, b := range buckets {
+		delays[i] = b.reserve(now)
+		if delays[i] > delay {
+			delay = delays[i]
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		cancelled := time.Now()
+		for i, b := range buckets {
+			b.cancel(cancelled, now.Add(delays[i]))
+		}
+		return ctx.Err()
+	}
+}
+
+// waitRateLimit wait for the rate limit of the request
+func (z *Zhttp) waitRateLimit(ctx context.Context, rawURL string, options *ReqOptions) error {
+	if z.limiter == nil {
+		return nil
+	}
+
+	host := options.HostIP
+	if host == "" && options.Host != "" {
+		host = options.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if host == "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		host = u.Hostname()
+	}
+
+	return z.limiter.wait(ctx, host)
+}
@@ -0,0 +1,32 @@
+package zhttp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRateLimitHostKey(t *testing.T) {
+	z := New(&HTTPOptions{RateLimit: &RateLimit{HostRate: 0.001, FailFast: true}})
+	ctx := context.Background()
+
+	tests := []struct {
+		url     string
+		options *ReqOptions
+		err     error
+	}{
+		{"http://example.com/", &ReqOptions{HostIP: "10.0.0.1"}, nil},
+		{"http://example.com/", &ReqOptions{HostIP: "10.0.0.2"}, nil},
+		{"http://example.com/", &ReqOptions{HostIP: "10.0.0.1", Host: "other.com"}, ErrRateLimited},
+		{"http://example.com/", &ReqOptions{Host: "Host.com:8080"}, nil},
+		{"http://host.com/", &ReqOptions{}, ErrRateLimited},
+		{"http://EXAMPLE.com/", &ReqOptions{}, nil},
+		{"http://example.com:8080/", &ReqOptions{}, ErrRateLimited},
+	}
+
+	for _, tt := range tests {
+		err := z.waitRateLimit(ctx, tt.url, tt.options)
+		if err != tt.err {
+			t.Errorf("waitRateLimit(%s, %+v) = %v, want %v", tt.url, tt.options, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package zhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RawHeader is a header line of the raw request, the name is sent as is,
+// without canonicalization
+type RawHeader struct {
+	Name  string
+	Value string
+}
+
+// BuildRawRequest build a HTTP/1.1 request in wire format with the headers in order.
+// uri is written to the request line as is, so it can be an unnormalized path like /a/../b.
+// Headers like Host and Content-Length will not be added automatically
+func BuildRawRequest(method, uri string, headers []RawHeader, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(method)
+	buf.WriteByte(' ')
+	buf.WriteString(uri)
+	buf.WriteString(" HTTP/1.1\r\n")
+
+	for _, h := range headers {
+		buf.WriteString(h.Name)
+		buf.WriteString(": ")
+		buf.WriteString(h.Value)
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return buf.Bytes()
+}
+
+// ParseRawRequest parse a http request in wire format, such as the request copied from Burp Suite,
+// into method, url and ReqOptions. scheme is the scheme of url, because it is not included in the
+// request, if empty, http is used. The Host header is used as the host of url, Cookie, User-Agent,
+// Content-Type and Basic Authorization headers are mapped to the fields of ReqOptions.
+// NoUA is set when there is no User-Agent header, so that the request is sent as it is
+func ParseRawRequest(raw []byte, scheme string) (*ParsedRequest, error) {
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	head, body := raw, []byte(nil)
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		head, body = raw[:i], raw[i+4:]
+	} else if i := bytes.Index(raw, []byte("\n\n")); i >= 0 {
+		head, body = raw[:i], raw[i+2:]
+	}
+
+	lines := strings.Split(strings.TrimLeft(string(head), "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+
+	parts := strings.Fields(lines[0])
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("zhttp: malformed request line %q", lines[0])
+	}
+	method, uri := parts[0], parts[1]
+
+	options := &ReqOptions{
+		DisableRedirect: true,
+		NoUA:            true,
+	}
+
+	var host string
+	var chunked bool
+	var headers []RawHeader
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		// obsolete line folding
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("zhttp: malformed header line %q", line)
+		}
+		headers = append(headers, RawHeader{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	for _, h := range headers {
+		switch strings.ToLower(h.Name) {
+		case "host":
+			host = h.Value
+		case "user-agent":
+			options.NoUA = false
+			options.UserAgent = h.Value
+		case "transfer-encoding":
+			if strings.EqualFold(h.Value, "chunked") {
+				chunked = true
+				continue
+			}
+			applyHeader(options, h.Name, h.Value)
+		case "authorization":
+			if username, password, ok := (&http.Request{Header: http.Header{"Authorization": {h.Value}}}).BasicAuth(); ok {
+				options.Auth = Auth{Username: username, Password: password}
+				continue
+			}
+			applyHeader(options, h.Name, h.Value)
+		default:
+			applyHeader(options, h.Name, h.Value)
+		}
+	}
+
+	// the request line may contain an absolute uri, like the request sent to proxy
+	rawURL := uri
+	if !strings.Contains(uri, "://") {
+		if host == "" {
+			return nil, errors.New("zhttp: no Host header in request")
+		}
+		rawURL = scheme + "://" + host + uri
+	} else if u, err := url.Parse(uri); err == nil && host != "" && host != u.Host {
+		options.Host = host
+	}
+
+	if chunked {
+		data, err := ioutil.ReadAll(httputil.NewChunkedReader(bytes.NewReader(body)))
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+	if len(body) > 0 {
+		options.Body = Bytes(body)
+	}
+
+	return &ParsedRequest{
+		Method:  method,
+		URL:     rawURL,
+		Options: options,
+	}, nil
+}
+
+// DumpRequest format the request which would be sent by Zhttp.Request to HTTP/1.1 wire format.
+// The Body which can not be reused, such as ReaderBody, will be consumed
+func (z *Zhttp) DumpRequest(method, rawURL string, options *ReqOptions) (string, error) {
+	if options == nil {
+		options = &ReqOptions{}
+	}
+
+	req, body, err := z.buildDumpRequest(method, rawURL, options)
+	if err != nil {
+		return "", err
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := []RawHeader{{Name: "Host", Value: host}}
+
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range req.Header[key] {
+			// the header with empty value is not sent, like the User-Agent when NoUA is set
+			if value != "" {
+				headers = append(headers, RawHeader{Name: key, Value: value})
+			}
+		}
+	}
+
+	if len(body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		headers = append(headers, RawHeader{Name: "Content-Length", Value: strconv.Itoa(len(body))})
+	}
+
+	return string(BuildRawRequest(req.Method, req.URL.RequestURI(), headers, body)), nil
+}
+
+// RawRequest send raw to the server of target exactly as it is, and parse the reply into *Response.
+// target is the url used to decide the address, scheme and proxy, its path and query are not used.
+// When an http proxy (not socks proxy) is used for a http target without HostIP, raw is sent to the proxy,
+// so the request line should use the absolute-form uri like GET http://example.com/ HTTP/1.1.
+// Only Timeout, RequestTimeout, Proxies, HostIP and the tls settings of options are effective,
+// and the middlewares and retry policy are not applied
+func (z *Zhttp) RawRequest(target string, raw []byte, options *ReqOptions) (*Response, error) {
+	return z.doRawRequest(context.Background(), target, raw, options)
+}
+
+// RawRequestContext is like RawRequest, but the request is bound to ctx
+func (z *Zhttp) RawRequestContext(ctx context.Context, target string, raw []byte, options *ReqOptions) (*Response, error) {
+	return z.doRawRequest(ctx, target, raw, options)
+}
+
+func (z *Zhttp) doRawRequest(parent context.Context, target string, raw []byte, options *ReqOptions) (*Response, error) {
+	err := z.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	return z.track(z.sendRawRequest(parent, target, raw, options))
+}
+
+// sendRawRequest send raw to the server of target and read the response
+func (z *Zhttp) sendRawRequest(parent context.Context, target string, raw []byte, options *ReqOptions) (*Response, error) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	if z.initErr != nil {
+		return nil, z.initErr
+	}
+
+	if options == nil {
+		options = &ReqOptions{}
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("zhttp: unsupported scheme %q", u.Scheme)
+	}
+
+	err = z.waitRateLimit(parent, target, options)
+	if err != nil {
+		return nil, err
+	}
+
+	requestTimeout := z.options.RequestTimeout
+	if options.RequestTimeout > 0 {
+		requestTimeout = options.RequestTimeout
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+
+	conn, err := z.dialRaw(ctx, u, options)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the connection is closed when the request is finished or canceled
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	timeout := z.options.Timeout
+	if options.Timeout > 0 {
+		timeout = options.Timeout
+	}
+
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, cancel)
+	}
+
+	reader := &ctxReader{ctx: ctx, r: conn}
+	resp, err := sendRaw(conn, bufio.NewReader(reader), raw)
+
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		cancel()
+		if errors.Is(err, context.Canceled) && parent.Err() == nil {
+			err = fmt.Errorf("%w (timeout exceeded while send request)", err)
+		}
+		return nil, err
+	}
+
+	resp.Request = &http.Request{
+		Method: resp.Request.Method,
+		URL:    u,
+		Header: http.Header{},
+		Host:   u.Host,
+	}
+
+	r := z.newDecodedResponse(resp, &ReaderWithCancel{
+		rc:      resp.Body,
+		parent:  parent,
+		cancel:  cancel,
+		timeout: timeout,
+	})
+	r.Attempts = 1
+	r.rawRequest = raw
+	r.Body.decodeCharset = z.options.DecodeCharset
+
+	return r, nil
+}
+
+// sendRaw write raw to conn and read the response
+func sendRaw(conn net.Conn, br *bufio.Reader, raw []byte) (*http.Response, error) {
+	_, err := conn.Write(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// the method is needed to know whether the response has body
+	method := "GET"
+	if i := bytes.IndexByte(raw, ' '); i > 0 {
+		method = string(raw[:i])
+	}
+
+	return http.ReadResponse(br, &http.Request{Method: method})
+}
+
+// dialRaw establish the connection to target through the dialer, proxy and tls settings of Zhttp
+func (z *Zhttp) dialRaw(ctx context.Context, u *url.URL, options *ReqOptions) (net.Conn, error) {
+	optionCtx := context.WithValue(ctx, ctxOptionKey, options)
+
+	proxyURL, err := z.transport.Proxy((&http.Request{URL: u, Header: http.Header{}}).WithContext(optionCtx))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := canonicalAddr(u)
+
+	var conn net.Conn
+	switch {
+	case proxyURL == nil:
+		conn, err = z.transport.DialContext(optionCtx, "tcp", addr)
+	case isSocksProxy(proxyURL):
+		conn, err = z.roundTripper.dialSocks(optionCtx, proxyURL, "tcp", addr)
+	case u.Scheme == "https" || options.HostIP != "":
+		// the tunnel is established to HostIP if it is set, so that the proxy will not resolve the host
+		if options.HostIP != "" {
+			_, port, _ := net.SplitHostPort(addr)
+			addr = net.JoinHostPort(options.HostIP, port)
+		}
+		conn, err = z.roundTripper.dialTunnel(ctx, proxyURL, addr)
+	default:
+		// raw is sent to the http proxy as it is
+		conn, err = z.roundTripper.dialProxy(ctx, proxyURL)
+		if err == nil && proxyURL.Scheme == "https" {
+			conn, err = z.roundTripper.tlsClient(ctx, conn, proxyURL.Hostname(), nil)
+		}
+		return conn, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		return z.roundTripper.tlsClient(ctx, conn, u.Hostname(), options)
+	}
+
+	return conn, nil
+}
+
+// proxyConnect send CONNECT request to http proxy to establish a tunnel to addr
+func proxyConnect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("zhttp: proxy refused CONNECT: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// canonicalAddr return host:port of url, with default port of the scheme
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h", "socks4", "socks4a":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// ctxReader return the error of ctx instead of the error caused by closing connection
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return n, err
+}
@@ -2,6 +2,7 @@ package zhttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -9,9 +10,19 @@ import (
 
 type ReaderWithCancel struct {
 	rc      io.ReadCloser
+	parent  context.Context
 	cancel  context.CancelFunc
 	timeout time.Duration
 	timer   *time.Timer
+	// done will be called once when the body is read to the end or closed
+	done func()
+}
+
+func (r *ReaderWithCancel) finish() {
+	if r.done != nil {
+		r.done()
+		r.done = nil
+	}
 }
 
 func (r *ReaderWithCancel) readWithTimeout(p []byte) (int, error) {
@@ -25,7 +36,7 @@ func (r *ReaderWithCancel) readWithTimeout(p []byte) (int, error) {
 
 	r.timer.Stop()
 
-	if err == context.Canceled {
+	if errors.Is(err, context.Canceled) && (r.parent == nil || r.parent.Err() == nil) {
 		err = fmt.Errorf("%w (timeout exceeded while read body)", err)
 	}
 
@@ -34,13 +45,20 @@ func (r *ReaderWithCancel) readWithTimeout(p []byte) (int, error) {
 
 func (r *ReaderWithCancel) Read(p []byte) (n int, err error) {
 	if r.timeout > 0 {
-		return r.readWithTimeout(p)
+		n, err = r.readWithTimeout(p)
+	} else {
+		n, err = r.rc.Read(p)
 	}
 
-	return r.rc.Read(p)
+	if err == io.EOF {
+		r.finish()
+	}
+
+	return n, err
 }
 
 func (r *ReaderWithCancel) Close() error {
+	r.finish()
 	r.cancel()
 	return r.rc.Close()
 }
@@ -3,13 +3,15 @@ package zhttp
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"time"
-
-	"github.com/greyh4t/dnscache"
 )
 
 var ctxOptionKey = struct{}{}
@@ -21,7 +23,7 @@ func disableRedirect(req *http.Request, via []*http.Request) error {
 // buildClient make a new client
 func (z *Zhttp) buildClient(httpOptions *HTTPOptions, reqOptions *ReqOptions, cookieJar http.CookieJar) *http.Client {
 	client := &http.Client{
-		Transport: z.transport,
+		Transport: z.roundTripper,
 		Jar:       cookieJar,
 		Timeout:   httpOptions.RequestTimeout,
 	}
@@ -38,7 +40,7 @@ func (z *Zhttp) buildClient(httpOptions *HTTPOptions, reqOptions *ReqOptions, co
 }
 
 // createTransport create a global *http.Transport for all http client
-func createTransport(options *HTTPOptions, cache *dnscache.Cache) *http.Transport {
+func createTransport(options *HTTPOptions, tlsConfig *tls.Config, dialer *net.Dialer, resolver Resolver) *http.Transport {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 
 	transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
@@ -46,9 +48,7 @@ func createTransport(options *HTTPOptions, cache *dnscache.Cache) *http.Transpor
 	transport.DisableKeepAlives = options.DisableKeepAlives
 	transport.DisableCompression = options.DisableCompression
 
-	if options.InsecureSkipVerify {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
-	}
+	setTLSConfig(transport, tlsConfig)
 	if options.IdleConnTimeout > 0 {
 		transport.IdleConnTimeout = options.IdleConnTimeout
 	}
@@ -60,20 +60,24 @@ func createTransport(options *HTTPOptions, cache *dnscache.Cache) *http.Transpor
 	}
 
 	transport.Proxy = func(req *http.Request) (*url.URL, error) {
-		reqOptions, ok := req.Context().Value(ctxOptionKey).(*ReqOptions)
-		if ok && len(reqOptions.Proxies) > 0 {
-			if p, ok := reqOptions.Proxies[req.URL.Scheme]; ok {
-				return p, nil
-			}
-		} else if len(options.Proxies) > 0 {
-			if p, ok := options.Proxies[req.URL.Scheme]; ok {
-				return p, nil
-			}
+		// the proxy has been selected by roundTripper
+		if choice, ok := req.Context().Value(ctxProxyChoiceKey).(*proxyChoice); ok {
+			return choice.url, nil
 		}
-		// get proxy from environment
-		return http.ProxyFromEnvironment(req)
+		choice, err := selectProxy(options, req, true)
+		if err != nil {
+			return nil, err
+		}
+		return choice.url, nil
 	}
 
+	transport.DialContext = makeDialContext(dialer, resolver, options.IPFamily)
+
+	return transport
+}
+
+// newDialer create the dialer for all connections
+func newDialer(options *HTTPOptions) *net.Dialer {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
@@ -85,32 +89,102 @@ func createTransport(options *HTTPOptions, cache *dnscache.Cache) *http.Transpor
 		dialer.KeepAlive = options.KeepAlive
 	}
 
-	transport.DialContext = makeDialContext(dialer, cache)
-
-	return transport
+	return dialer
 }
 
-func makeDialContext(dialer *net.Dialer, cache *dnscache.Cache) func(ctx context.Context, network, addr string) (net.Conn, error) {
+func makeDialContext(dialer *net.Dialer, resolver Resolver, family IPFamily) func(ctx context.Context, network, addr string) (net.Conn, error) {
 	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		// the request with HostIP through http proxy is tunneled by roundTripper,
+		// so the address is always the target when HostIP is set
 		reqOptions, ok := ctx.Value(ctxOptionKey).(*ReqOptions)
 		if ok && reqOptions.HostIP != "" {
 			_, port, _ := net.SplitHostPort(address)
 			address = net.JoinHostPort(reqOptions.HostIP, port)
-		} else if cache != nil {
-			host, port, _ := net.SplitHostPort(address)
-			ip, err := cache.FetchOneV4String(host)
-			if err != nil {
-				return nil, err
-			}
-			address = net.JoinHostPort(ip, port)
+			return dialer.DialContext(ctx, network, address)
 		}
 
+		return dialAddress(ctx, dialer, resolver, family, network, address)
+	}
+}
+
+// dialAddress dial the address, the host will be resolved by resolver if it is not nil.
+// The ips of family are dialed by Happy Eyeballs, the next ip is tried if the connection to one fails
+func dialAddress(ctx context.Context, dialer *net.Dialer, resolver Resolver, family IPFamily, network, address string) (net.Conn, error) {
+	// the dialer of go resolves the host and falls back between the ips by itself
+	if resolver == nil && family == IPDefault {
 		return dialer.DialContext(ctx, network, address)
 	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := lookupIPs(ctx, resolver, host, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialHappyEyeballs(ctx, dialer, network, ips, port)
+}
+
+// lookupIPs resolve the host by resolver, or by the system resolver if it is nil, and report the lookup to httptrace.
+// The ips are sorted by family, the error is returned if there is no ip of family
+func lookupIPs(ctx context.Context, resolver Resolver, host string, family IPFamily) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if resolver == nil {
+		resolver = &SystemResolver{}
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	ips, err := resolver.LookupIP(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, ip := range ips {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: ip})
+		}
+		trace.DNSDone(info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ips = sortIPs(ips, family)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host}
+	}
+
+	return ips, nil
 }
 
 // doRequest send request with http client to server
-func (z *Zhttp) doRequest(method, rawURL string, options *ReqOptions, jar http.CookieJar) (*Response, error) {
+func (z *Zhttp) doRequest(parent context.Context, method, rawURL string, options *ReqOptions, s *Session) (*Response, error) {
+	err := z.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	return z.track(z.retryRequest(parent, method, rawURL, options, s))
+}
+
+// retryRequest send the request, and retry it by the retry policy
+func (z *Zhttp) retryRequest(parent context.Context, method, rawURL string, options *ReqOptions, s *Session) (*Response, error) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	if z.initErr != nil {
+		return nil, z.initErr
+	}
+
 	if options == nil {
 		options = &ReqOptions{}
 	}
@@ -120,8 +194,74 @@ func (z *Zhttp) doRequest(method, rawURL string, options *ReqOptions, jar http.C
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	req, err := z.buildRequest(ctx, method, rawURL, options)
+	policy := z.options.Retry
+	if options.Retry != nil {
+		policy = options.Retry
+	}
+
+	// the body which can not be reused is buffered for the retries, unless it should be sent once
+	streaming := options.Body != nil && !isReplayable(options.Body)
+	if !policy.enabled() || streaming && policy.SendStreamOnce {
+		err = z.waitRateLimit(parent, rawURL, options)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := z.send(parent, method, rawURL, options, options.Body, s)
+		if err != nil {
+			return nil, err
+		}
+		resp.Attempts = 1
+		return resp, nil
+	}
+
+	body, err := replayableBody(options.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = z.waitRateLimit(parent, rawURL, options)
+		if err != nil {
+			if attempt > 1 {
+				err = &RetryError{Attempts: attempt - 1, Err: err}
+			}
+			return nil, err
+		}
+		resp, err := z.send(parent, method, rawURL, options, body, s)
+		if attempt >= policy.MaxAttempts || parent.Err() != nil || !policy.shouldRetry(resp, err) {
+			if err != nil {
+				return nil, &RetryError{Attempts: attempt, Err: err}
+			}
+			resp.Attempts = attempt
+			return resp, nil
+		}
+
+		wait := policy.backoff(attempt, resp)
+		if resp != nil {
+			resp.discard()
+		}
+
+		err = sleepContext(parent, wait)
+		if err != nil {
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// send make a single attempt of the request
+func (z *Zhttp) send(parent context.Context, method, rawURL string, options *ReqOptions, body Body, s *Session) (*Response, error) {
+	recorder := &timingRecorder{}
+	ctx := context.WithValue(httptrace.WithClientTrace(parent, recorder.clientTrace()), ctxTimingKey, recorder)
+	ctx, cancel := context.WithCancel(ctx)
+	if s != nil {
+		if p := s.pinned(); p != nil {
+			ctx = context.WithValue(ctx, ctxSessionProxyKey, p)
+		}
+	}
+	if auth := z.authenticator(options, s); auth != nil {
+		ctx = context.WithValue(ctx, ctxAuthKey, auth)
+	}
+	req, err := z.buildRequest(ctx, method, rawURL, options, body)
 	if err != nil {
 		cancel()
 		return nil, err
@@ -129,7 +269,12 @@ func (z *Zhttp) doRequest(method, rawURL string, options *ReqOptions, jar http.C
 
 	z.addCookies(req, options)
 	z.addHeaders(req, options)
+	z.acceptEncoding(req)
 
+	var jar http.CookieJar
+	if s != nil && s.CookieJar != nil {
+		jar = s.CookieJar
+	}
 	client := z.buildClient(z.options, options, jar)
 
 	timeout := z.options.Timeout
@@ -137,35 +282,75 @@ func (z *Zhttp) doRequest(method, rawURL string, options *ReqOptions, jar http.C
 		timeout = options.Timeout
 	}
 
-	resp, err := z.do(client, req, cancel, timeout)
+	sent := false
+	roundTrip := z.chainMiddlewares(func(req *http.Request) (*Response, error) {
+		sent = true
+		resp, err := z.do(parent, client, req, cancel, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		hops := recorder.timings()
+		timings := hops[len(hops)-1]
+
+		r := z.newDecodedResponse(resp, &ReaderWithCancel{
+			rc:      resp.Body,
+			parent:  parent,
+			cancel:  cancel,
+			timeout: timeout,
+			done: func() {
+				recorder.bodyDone(timings)
+			},
+		})
+		r.Timings = timings
+		r.RedirectTimings = hops[:len(hops)-1]
+		if choice, ok := resp.Request.Context().Value(ctxProxyChoiceKey).(*proxyChoice); ok {
+			r.proxy = choice.url
+		}
+
+		return r, nil
+	}, s, options)
+
+	resp, err := roundTrip(req)
+	// the body is closed by the client once the request is sent, otherwise it is closed here,
+	// so that the producer of the body, such as CompressedBody and MultipartStream, exits
+	if !sent && req.Body != nil {
+		req.Body.Close()
+	}
+	if err == nil && resp == nil {
+		err = errNilResponse
+	} else if err == nil && (resp.Body == nil || resp.Body.rawBody == nil) {
+		err = errNilBody
+	}
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 
-	return &Response{
-		RawResponse:   resp,
-		StatusCode:    resp.StatusCode,
-		Status:        resp.Status,
-		ContentLength: resp.ContentLength,
-		Headers:       Headers(resp.Header),
-		Body: &ZBody{
-			rawBody: &ReaderWithCancel{
-				rc:      resp.Body,
-				cancel:  cancel,
-				timeout: timeout,
-			},
-		},
-	}, nil
+	// the request is short-circuited by middleware
+	if !sent {
+		cancel()
+	}
+
+	if resp.z == nil {
+		resp.z = z
+		resp.options = options
+		resp.Body.decodeCharset = z.options.DecodeCharset
+	}
+
+	return resp, nil
 }
 
-func (z *Zhttp) do(client *http.Client, req *http.Request, cancel context.CancelFunc,
+// do send the request, cancel will be called if no response is received within timeout.
+// parent is the context passed by caller, it is used to distinguish the cancellation
+// from caller and the timeout
+func (z *Zhttp) do(parent context.Context, client *http.Client, req *http.Request, cancel context.CancelFunc,
 	timeout time.Duration) (*http.Response, error) {
 	if timeout > 0 {
 		timer := time.AfterFunc(timeout, cancel)
 		resp, err := client.Do(req)
 		timer.Stop()
-		if err == context.Canceled {
+		if errors.Is(err, context.Canceled) && parent.Err() == nil {
 			err = fmt.Errorf("%w (timeout exceeded while send request)", err)
 		}
 		return resp, err
@@ -175,16 +360,17 @@ func (z *Zhttp) do(client *http.Client, req *http.Request, cancel context.Cancel
 }
 
 // buildRequest build request with body and other
-func (z *Zhttp) buildRequest(ctx context.Context, method, rawURL string, options *ReqOptions) (*http.Request, error) {
-	if len(options.Proxies) > 0 || options.HostIP != "" {
+func (z *Zhttp) buildRequest(ctx context.Context, method, rawURL string, options *ReqOptions, body Body) (*http.Request, error) {
+	if len(options.Proxies) > 0 || options.ProxyPool != nil || options.HostIP != "" || options.ServerName != "" ||
+		options.InsecureSkipVerify || len(options.ClientCertificates) > 0 || len(options.NextProtos) > 0 {
 		ctx = context.WithValue(ctx, ctxOptionKey, options)
 	}
 
-	if options.Body == nil {
+	if body == nil {
 		return http.NewRequestWithContext(ctx, method, rawURL, nil)
 	}
 
-	bodyReader, contentType, err := options.Body.Content()
+	bodyReader, contentType, err := body.Content()
 	if err != nil {
 		return nil, err
 	}
@@ -198,6 +384,24 @@ func (z *Zhttp) buildRequest(ctx context.Context, method, rawURL string, options
 		req.Header.Set("Content-Type", contentType)
 	}
 
+	if e, ok := body.(interface{ ContentEncoding() string }); ok {
+		req.Header.Set("Content-Encoding", e.ContentEncoding())
+	}
+
+	// the reader of compressed body can not be replayed by go, get a new one from body for redirects
+	if req.GetBody == nil && isReplayable(body) {
+		req.GetBody = func() (io.ReadCloser, error) {
+			reader, _, err := body.Content()
+			if err != nil {
+				return nil, err
+			}
+			if rc, ok := reader.(io.ReadCloser); ok {
+				return rc, nil
+			}
+			return ioutil.NopCloser(reader), nil
+		}
+	}
+
 	return req, nil
 }
 
@@ -0,0 +1,524 @@
+package zhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greyh4t/dnscache"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver resolve the host to ips, it is used to dial the hosts of requests and proxies
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// TTLResolver is a Resolver which also returns how long the ips can be cached, such as DoHResolver and DoTResolver
+type TTLResolver interface {
+	Resolver
+	LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+}
+
+// dnsTimeout is the timeout of a lookup by DoH or DoT if ctx has no deadline
+const dnsTimeout = 5 * time.Second
+
+// SystemResolver resolve the host by the resolver of go, which reads /etc/hosts and /etc/resolv.conf
+type SystemResolver struct {
+	// Server is the dns server like 1.1.1.1:53, the server of system is used if it is empty.
+	// It is only effective in linux
+	Server string
+}
+
+func (r *SystemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	resolver := net.DefaultResolver
+	if r.Server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, r.Server)
+			},
+		}
+	}
+
+	// the lookup is reported to httptrace by the caller, so the trace of ctx is not passed
+	addrs, err := resolver.LookupIPAddr(valuelessContext{ctx}, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+
+	return ips, nil
+}
+
+// CacheResolver resolve the host by dns cache, which is used when HTTPOptions.DNSCacheExpire is set
+type CacheResolver struct {
+	Cache *dnscache.Cache
+}
+
+func (r *CacheResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return r.Cache.Fetch(host)
+}
+
+// TTLCacheResolver cache the ips resolved by Resolver, such as
+// &TTLCacheResolver{Resolver: &DoHResolver{URL: "https://1.1.1.1/dns-query"}}.
+// The ips are cached for the ttl of the records if Resolver is a TTLResolver, which is limited by MaxTTL,
+// otherwise they are cached for MaxTTL. The errors are not cached, and the concurrent lookups of a host are merged
+type TTLCacheResolver struct {
+	Resolver Resolver
+
+	// MaxTTL is the max time to cache the ips, default is 5 minutes
+	MaxTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*ttlCacheEntry
+	sweepAt int
+}
+
+type ttlCacheEntry struct {
+	// ready is closed when the lookup is done, the fields below are set before that
+	ready   chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+func (r *TTLCacheResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	key := strings.TrimSuffix(strings.ToLower(host), ".")
+	now := time.Now()
+
+	r.mu.Lock()
+	e, ok := r.entries[key]
+	if !ok || e.isExpired(now) {
+		e = &ttlCacheEntry{ready: make(chan struct{})}
+		r.add(key, e, now)
+		r.mu.Unlock()
+
+		go r.lookup(key, host, e)
+	} else {
+		r.mu.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return append([]net.IP(nil), e.ips...), nil
+}
+
+// isExpired report whether the lookup is done and the ips are expired, it must be called with mu held
+func (e *ttlCacheEntry) isExpired(now time.Time) bool {
+	select {
+	case <-e.ready:
+		return !now.Before(e.expires)
+	default:
+		return false
+	}
+}
+
+// add put the entry of key, the expired entries are removed when the cache grows, it must be called with mu held
+func (r *TTLCacheResolver) add(key string, e *ttlCacheEntry, now time.Time) {
+	if r.entries == nil {
+		r.entries = map[string]*ttlCacheEntry{}
+	}
+
+	if len(r.entries) >= r.sweepAt {
+		for k, old := range r.entries {
+			if old.isExpired(now) {
+				delete(r.entries, k)
+			}
+		}
+		r.sweepAt = 2 * len(r.entries)
+		if r.sweepAt < 1024 {
+			r.sweepAt = 1024
+		}
+	}
+
+	r.entries[key] = e
+}
+
+// lookup resolve the host for the entry, the entry is removed if it should not be cached.
+// The lookup is shared by the concurrent callers, so it is not cancelled by any of them but limited by dnsTimeout
+func (r *TTLCacheResolver) lookup(key, host string, e *ttlCacheEntry) {
+	maxTTL := r.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = 5 * time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	ttl := maxTTL
+	if tr, ok := r.Resolver.(TTLResolver); ok {
+		e.ips, ttl, e.err = tr.LookupIPTTL(ctx, host)
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+	} else {
+		e.ips, e.err = r.Resolver.LookupIP(ctx, host)
+	}
+	e.expires = time.Now().Add(ttl)
+
+	r.mu.Lock()
+	close(e.ready)
+	if (e.err != nil || ttl <= 0) && r.entries[key] == e {
+		delete(r.entries, key)
+	}
+	r.mu.Unlock()
+}
+
+// HostsResolver resolve the host by the static map like /etc/hosts, such as
+// {"api.example.com": {"10.0.0.1"}, "*.example.com": {"10.0.0.2", "::2"}}.
+// The wildcard matches all subdomains, and "*" matches all hosts, the exact host is preferred over the wildcard.
+// The error of not found is returned for the host not in the map, so the next resolver of ChainResolver is used
+type HostsResolver map[string][]string
+
+func (r HostsResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	var matched string
+	var values []string
+	for pattern, list := range r {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if pattern == host {
+			matched, values = pattern, list
+			break
+		}
+		if pattern != "*" && !(strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			continue
+		}
+
+		// the longer wildcard is more specific
+		if matched == "" || len(pattern) > len(matched) {
+			matched, values = pattern, list
+		}
+	}
+
+	if matched == "" {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	ips := make([]net.IP, 0, len(values))
+	for _, v := range values {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("zhttp: invalid ip %q of %s in hosts", v, matched)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// ChainResolver try the resolvers in order until one of them returns the ips,
+// such as the overrides by HostsResolver first and DoHResolver as the fallback.
+// The error of the last resolver is returned if all of them fail
+type ChainResolver []Resolver
+
+func (r ChainResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	err := error(&net.DNSError{Err: "no such host", Name: host, IsNotFound: true})
+	for _, resolver := range r {
+		var ips []net.IP
+		ips, err = resolver.LookupIP(ctx, host)
+		if err == nil && len(ips) > 0 {
+			return ips, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, err
+}
+
+// DoHResolver resolve the host by DNS over HTTPS (RFC 8484)
+type DoHResolver struct {
+	// URL is the url of DoH server, such as https://1.1.1.1/dns-query
+	URL string
+
+	// Client is used to send the dns queries, http.DefaultClient is used if nil.
+	// The host of URL is resolved by the system resolver of Client
+	Client *http.Client
+}
+
+func (r *DoHResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL resolve the host like LookupIP, and return the min ttl of the records
+func (r *DoHResolver) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return lookupDNS(ctx, host, func(ctx context.Context, query []byte) ([]byte, error) {
+		// the id should be 0 in DoH, so that the response can be cached by http
+		query[0], query[1] = 0, 0
+
+		req, err := http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("zhttp: doh server returned %s", resp.Status)
+		}
+
+		return ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	})
+}
+
+// DoTResolver resolve the host by DNS over TLS (RFC 7858)
+type DoTResolver struct {
+	// Addr is the address of DoT server, such as 1.1.1.1:853, the port is 853 if it is omitted
+	Addr string
+
+	// ServerName is used to verify the certificate of server, the host of Addr is used if it is empty
+	ServerName string
+
+	// TLSConfig is the tls config to connect the server, such as the RootCAs
+	TLSConfig *tls.Config
+}
+
+func (r *DoTResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL resolve the host like LookupIP, and return the min ttl of the records
+func (r *DoTResolver) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	addr := r.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "853")
+	}
+
+	config := &tls.Config{}
+	if r.TLSConfig != nil {
+		config = r.TLSConfig.Clone()
+	}
+	if r.ServerName != "" {
+		config.ServerName = r.ServerName
+	} else if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	return lookupDNS(ctx, host, func(ctx context.Context, query []byte) ([]byte, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		}
+
+		// the messages over tcp are prefixed with the length
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		_, err = tlsConn.Write(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		var length [2]byte
+		_, err = io.ReadFull(tlsConn, length[:])
+		if err != nil {
+			return nil, err
+		}
+		reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = io.ReadFull(tlsConn, reply)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(reply) < 2 || !bytes.Equal(reply[:2], query[:2]) {
+			return nil, errors.New("zhttp: dot server replied a mismatched message")
+		}
+
+		return reply, nil
+	})
+}
+
+// lookupDNS query the A and AAAA records of host concurrently by exchange,
+// which sends the query message and returns the reply message. The min ttl of the records is returned
+func lookupDNS(ctx context.Context, host string, exchange func(ctx context.Context, query []byte) ([]byte, error)) ([]net.IP, time.Duration, error) {
+	// the dns queries should not inherit the values of the request, such as httptrace
+	ctx = valuelessContext{ctx}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dnsTimeout)
+		defer cancel()
+	}
+
+	fqdn := host
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: "invalid host name", Name: host}
+	}
+
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+
+	type result struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	results := make([]chan result, len(types))
+
+	for i, t := range types {
+		results[i] = make(chan result, 1)
+		go func(t dnsmessage.Type, ch chan<- result) {
+			ips, ttl, err := queryDNS(ctx, host, name, t, exchange)
+			ch <- result{ips: ips, ttl: ttl, err: err}
+		}(t, results[i])
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		if len(r.ips) > 0 && (len(ips) == 0 || r.ttl < ttl) {
+			ttl = r.ttl
+		}
+		ips = append(ips, r.ips...)
+	}
+
+	// the host may have only one type of records
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// queryDNS query the records of type t and parse the ips from the reply, the min ttl of the answers is returned
+func queryDNS(ctx context.Context, host string, name dnsmessage.Name, t dnsmessage.Type,
+	exchange func(ctx context.Context, query []byte) ([]byte, error)) ([]net.IP, time.Duration, error) {
+	var id [2]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: t, Class: dnsmessage.ClassINET},
+		},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reply, err := exchange(ctx, query)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, IsTemporary: true}
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(reply)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: "server misbehaving: " + header.RCode.String(), Name: host, IsTemporary: true}
+	}
+
+	err = p.SkipAllQuestions()
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+	}
+
+	// the records of CNAME are skipped, the addresses of the canonical name are in the same answer
+	var ips []net.IP
+	var ttl uint32
+	for i := 0; ; i++ {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+		}
+		if i == 0 || h.TTL < ttl {
+			ttl = h.TTL
+		}
+
+		switch {
+		case h.Type == dnsmessage.TypeA && t == dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+			}
+			ips = append(ips, net.IP(append([]byte(nil), r.A[:]...)))
+		case h.Type == dnsmessage.TypeAAAA && t == dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+			}
+			ips = append(ips, net.IP(append([]byte(nil), r.AAAA[:]...)))
+		default:
+			err = p.SkipAnswer()
+			if err != nil {
+				return nil, 0, &net.DNSError{Err: "cannot unmarshal DNS message", Name: host}
+			}
+		}
+	}
+
+	return ips, time.Duration(ttl) * time.Second, nil
+}
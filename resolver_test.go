@@ -0,0 +1,494 @@
+package zhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+func (f resolverFunc) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return f(ctx, host)
+}
+
+type ttlResolverFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+func (f ttlResolverFunc) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := f(ctx, host)
+	return ips, err
+}
+
+func (f ttlResolverFunc) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return f(ctx, host)
+}
+
+func staticResolver(ips ...string) resolverFunc {
+	return func(ctx context.Context, host string) ([]net.IP, error) {
+		return parseIPs(ips), nil
+	}
+}
+
+func parseIPs(list []string) []net.IP {
+	ips := make([]net.IP, len(list))
+	for i, s := range list {
+		ips[i] = net.ParseIP(s)
+	}
+	return ips
+}
+
+// ipStrings return the sorted ips in string
+func ipStrings(ips []net.IP) string {
+	list := make([]string, len(ips))
+	for i, ip := range ips {
+		list[i] = ip.String()
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func TestHostsResolver(t *testing.T) {
+	r := HostsResolver{
+		"api.example.com":   {"10.0.0.1"},
+		"*.example.com":     {"10.0.0.2", "::2"},
+		"*.a.example.com":   {"10.0.0.3"},
+		"Upper.Example.Net": {"10.0.0.4"},
+		"*":                 {"10.0.0.5"},
+	}
+
+	tests := []struct {
+		host string
+		ips  string
+	}{
+		{"api.example.com", "10.0.0.1"},
+		{"API.example.com.", "10.0.0.1"},
+		{"www.example.com", "10.0.0.2,::2"},
+		{"b.a.example.com", "10.0.0.3"},
+		{"a.example.com", "10.0.0.2,::2"},
+		{"upper.example.net", "10.0.0.4"},
+		{"example.com", "10.0.0.5"},
+		{"other.org", "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		ips, err := r.LookupIP(context.Background(), tt.host)
+		if err != nil {
+			t.Errorf("LookupIP(%s) returned error %v", tt.host, err)
+			continue
+		}
+		if got := ipStrings(ips); got != tt.ips {
+			t.Errorf("LookupIP(%s) = %s, want %s", tt.host, got, tt.ips)
+		}
+	}
+
+	r = HostsResolver{"*.example.com": {"10.0.0.2"}, "bad.example.com": {"invalid"}}
+
+	_, err := r.LookupIP(context.Background(), "example.com")
+	if !isNotFound(err) {
+		t.Errorf("got error %v for the host not in hosts, want not found", err)
+	}
+
+	_, err = r.LookupIP(context.Background(), "bad.example.com")
+	if err == nil || isNotFound(err) {
+		t.Errorf("got error %v for the invalid ip, want invalid ip", err)
+	}
+}
+
+func TestChainResolver(t *testing.T) {
+	var calls []string
+	called := func(name string, r Resolver) resolverFunc {
+		return func(ctx context.Context, host string) ([]net.IP, error) {
+			calls = append(calls, name)
+			return r.LookupIP(ctx, host)
+		}
+	}
+
+	r := ChainResolver{
+		called("hosts", HostsResolver{"api.example.com": {"10.0.0.1"}}),
+		called("empty", staticResolver()),
+		called("fallback", staticResolver("10.0.0.2")),
+		called("unused", staticResolver("10.0.0.3")),
+	}
+
+	ips, err := r.LookupIP(context.Background(), "api.example.com")
+	if err != nil || ipStrings(ips) != "10.0.0.1" || strings.Join(calls, ",") != "hosts" {
+		t.Errorf("got %v %v, called %v", ips, err, calls)
+	}
+
+	calls = nil
+	ips, err = r.LookupIP(context.Background(), "www.example.com")
+	if err != nil || ipStrings(ips) != "10.0.0.2" || strings.Join(calls, ",") != "hosts,empty,fallback" {
+		t.Errorf("got %v %v, called %v", ips, err, calls)
+	}
+
+	// the error of the last resolver is returned
+	lastErr := errors.New("last error")
+	r = ChainResolver{
+		HostsResolver{},
+		resolverFunc(func(ctx context.Context, host string) ([]net.IP, error) { return nil, lastErr }),
+	}
+	_, err = r.LookupIP(context.Background(), "www.example.com")
+	if err != lastErr {
+		t.Errorf("got error %v, want %v", err, lastErr)
+	}
+
+	_, err = ChainResolver{}.LookupIP(context.Background(), "www.example.com")
+	if !isNotFound(err) {
+		t.Errorf("got error %v of empty chain, want not found", err)
+	}
+
+	// stop when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = nil
+	r = ChainResolver{
+		called("cancel", resolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+			cancel()
+			return nil, errors.New("timeout")
+		})),
+		called("unused", staticResolver("10.0.0.3")),
+	}
+	_, err = r.LookupIP(ctx, "www.example.com")
+	if err != context.Canceled || strings.Join(calls, ",") != "cancel" {
+		t.Errorf("got error %v, called %v", err, calls)
+	}
+}
+
+// dnsTestRecords is the records answered by the stub dns servers,
+// the host starts with "alias." is answered by the CNAME to the rest of the host with ttl 30
+var dnsTestRecords = map[string][]string{
+	"example.com":      {"10.0.0.1", "10.0.0.2", "::1"},
+	"ipv4.example.com": {"10.0.0.3"},
+}
+
+// dnsTestReply build the reply of query by dnsTestRecords, the ttl of the address records is 300
+func dnsTestReply(t *testing.T, query []byte) []byte {
+	var msg dnsmessage.Message
+	err := msg.Unpack(query)
+	if err != nil || len(msg.Questions) != 1 {
+		t.Errorf("invalid query %x: %v", query, err)
+		return nil
+	}
+	q := msg.Questions[0]
+
+	reply := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: msg.ID, Response: true, RecursionDesired: true, RecursionAvailable: true},
+		Questions: msg.Questions,
+	}
+
+	name := q.Name
+	host := strings.TrimSuffix(name.String(), ".")
+	if strings.HasPrefix(host, "alias.") {
+		host = strings.TrimPrefix(host, "alias.")
+		target := dnsmessage.MustNewName(host + ".")
+		reply.Answers = append(reply.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 30},
+			Body:   &dnsmessage.CNAMEResource{CNAME: target},
+		})
+		name = target
+	}
+
+	ips, ok := dnsTestRecords[host]
+	if !ok {
+		reply.RCode = dnsmessage.RCodeNameError
+		reply.Answers = nil
+	}
+	for _, s := range ips {
+		header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300}
+		ip := net.ParseIP(s)
+		if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+			r := &dnsmessage.AResource{}
+			copy(r.A[:], ip4)
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: header, Body: r})
+		} else if ip.To4() == nil && q.Type == dnsmessage.TypeAAAA {
+			r := &dnsmessage.AAAAResource{}
+			copy(r.AAAA[:], ip)
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: header, Body: r})
+		}
+	}
+
+	data, err := reply.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return data
+}
+
+func newDoHTestServer(t *testing.T, queries *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(queries, 1)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			t.Errorf("invalid doh request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		query, _ := ioutil.ReadAll(r.Body)
+		if len(query) < 2 || query[0] != 0 || query[1] != 0 {
+			t.Errorf("the id of doh query is not 0")
+		}
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsTestReply(t, query))
+	}))
+}
+
+func TestDoHResolver(t *testing.T) {
+	var queries int32
+	ts := newDoHTestServer(t, &queries)
+	defer ts.Close()
+
+	r := &DoHResolver{URL: ts.URL, Client: ts.Client()}
+
+	tests := []struct {
+		host string
+		ips  string
+		ttl  time.Duration
+	}{
+		{"example.com", "10.0.0.1,10.0.0.2,::1", 300 * time.Second},
+		{"ipv4.example.com", "10.0.0.3", 300 * time.Second},
+		{"alias.example.com", "10.0.0.1,10.0.0.2,::1", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		ips, ttl, err := r.LookupIPTTL(context.Background(), tt.host)
+		if err != nil {
+			t.Errorf("LookupIPTTL(%s) returned error %v", tt.host, err)
+			continue
+		}
+		if got := ipStrings(ips); got != tt.ips || ttl != tt.ttl {
+			t.Errorf("LookupIPTTL(%s) = %s %v, want %s %v", tt.host, got, ttl, tt.ips, tt.ttl)
+		}
+	}
+
+	_, err := r.LookupIP(context.Background(), "nx.example.com")
+	if !isNotFound(err) {
+		t.Errorf("got error %v of nxdomain, want not found", err)
+	}
+
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failed.Close()
+	_, err = (&DoHResolver{URL: failed.URL}).LookupIP(context.Background(), "example.com")
+	if err == nil || isNotFound(err) {
+		t.Errorf("got error %v of the failed server", err)
+	}
+}
+
+// newDoTTestServer start the stub DoT server with the certificate of example.com,
+// the id of the reply is changed if mismatch is set
+func newDoTTestServer(t *testing.T, mismatch bool) (addr string, roots *x509.CertPool, stop func()) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	roots = x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+
+				reply := dnsTestReply(t, query)
+				if mismatch {
+					reply[1]++
+				}
+				msg := make([]byte, 2+len(reply))
+				binary.BigEndian.PutUint16(msg, uint16(len(reply)))
+				copy(msg[2:], reply)
+				conn.Write(msg)
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), roots, func() {
+		ln.Close()
+		wg.Wait()
+		ts.Close()
+	}
+}
+
+func TestDoTResolver(t *testing.T) {
+	addr, roots, stop := newDoTTestServer(t, false)
+	defer stop()
+
+	r := &DoTResolver{Addr: addr, ServerName: "example.com", TLSConfig: &tls.Config{RootCAs: roots}}
+
+	ips, ttl, err := r.LookupIPTTL(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ipStrings(ips); got != "10.0.0.1,10.0.0.2,::1" || ttl != 300*time.Second {
+		t.Errorf("got %s %v", got, ttl)
+	}
+
+	_, err = r.LookupIP(context.Background(), "nx.example.com")
+	if !isNotFound(err) {
+		t.Errorf("got error %v of nxdomain, want not found", err)
+	}
+
+	// the certificate is not valid for the server name
+	r = &DoTResolver{Addr: addr, ServerName: "other.org", TLSConfig: &tls.Config{RootCAs: roots}}
+	_, err = r.LookupIP(context.Background(), "example.com")
+	if err == nil {
+		t.Error("the certificate of mismatched server name is accepted")
+	}
+}
+
+func TestDoTResolverMismatchedID(t *testing.T) {
+	addr, roots, stop := newDoTTestServer(t, true)
+	defer stop()
+
+	r := &DoTResolver{Addr: addr, ServerName: "example.com", TLSConfig: &tls.Config{RootCAs: roots}}
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	if err == nil || !strings.Contains(err.Error(), "mismatched") {
+		t.Errorf("got %v %v, want the error of mismatched message", ips, err)
+	}
+}
+
+func TestTTLCacheResolver(t *testing.T) {
+	var calls int32
+	counted := func(r Resolver) resolverFunc {
+		return func(ctx context.Context, host string) ([]net.IP, error) {
+			atomic.AddInt32(&calls, 1)
+			return r.LookupIP(ctx, host)
+		}
+	}
+
+	r := &TTLCacheResolver{Resolver: counted(staticResolver("10.0.0.1")), MaxTTL: 50 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		ips, err := r.LookupIP(context.Background(), "example.com")
+		if err != nil || ipStrings(ips) != "10.0.0.1" {
+			t.Fatalf("got %v %v", ips, err)
+		}
+	}
+	r.LookupIP(context.Background(), "EXAMPLE.com.")
+	if calls != 1 {
+		t.Errorf("resolver is called %d times, want 1", calls)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	r.LookupIP(context.Background(), "example.com")
+	if calls != 2 {
+		t.Errorf("resolver is called %d times after MaxTTL, want 2", calls)
+	}
+
+	// the errors are not cached
+	calls = 0
+	r = &TTLCacheResolver{Resolver: counted(HostsResolver{})}
+	for i := 0; i < 2; i++ {
+		_, err := r.LookupIP(context.Background(), "example.com")
+		if !isNotFound(err) {
+			t.Errorf("got error %v, want not found", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("resolver is called %d times for errors, want 2", calls)
+	}
+
+	// the ips whose ttl is 0 are not cached
+	calls = 0
+	r = &TTLCacheResolver{Resolver: ttlResolverFunc(func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return parseIPs([]string{"10.0.0.1"}), 0, nil
+	})}
+	r.LookupIP(context.Background(), "example.com")
+	r.LookupIP(context.Background(), "example.com")
+	if calls != 2 {
+		t.Errorf("resolver is called %d times for ttl 0, want 2", calls)
+	}
+}
+
+func TestTTLCacheResolverDoH(t *testing.T) {
+	var queries int32
+	ts := newDoHTestServer(t, &queries)
+	defer ts.Close()
+
+	r := &TTLCacheResolver{Resolver: &DoHResolver{URL: ts.URL, Client: ts.Client()}}
+	for i := 0; i < 3; i++ {
+		ips, err := r.LookupIP(context.Background(), "example.com")
+		if err != nil || ipStrings(ips) != "10.0.0.1,10.0.0.2,::1" {
+			t.Fatalf("got %v %v", ips, err)
+		}
+	}
+	// the A and AAAA queries of the first lookup
+	if n := atomic.LoadInt32(&queries); n != 2 {
+		t.Errorf("got %d queries, want 2", n)
+	}
+}
+
+func TestTTLCacheResolverConcurrent(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	r := &TTLCacheResolver{Resolver: resolverFunc(func(ctx context.Context, host string) ([]net.IP, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return parseIPs([]string{"10.0.0.1"}), nil
+	})}
+
+	// the waiting caller returns when its ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := r.LookupIP(ctx, "example.com")
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want deadline exceeded", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := r.LookupIP(context.Background(), "example.com")
+			if err != nil || ipStrings(ips) != "10.0.0.1" {
+				t.Errorf("got %v %v", ips, err)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("resolver is called %d times, want 1", n)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strings"
+
+	"golang.org/x/net/html/charset"
 )
 
 // ZBody is a wrapper for http.Response.ZBody
@@ -16,6 +19,9 @@ type ZBody struct {
 	buf       bytes.Buffer
 	bufCached bool
 	Err       error
+
+	contentType   string
+	decodeCharset bool
 }
 
 // Read is the implementation of the reader interface
@@ -64,8 +70,13 @@ func (b *ZBody) fillBuffer() {
 	b.rawBody.Close()
 }
 
-// String return the body in string type
+// String return the body in string type.
+// If HTTPOptions.DecodeCharset is set, it is the same as Text
 func (b *ZBody) String() string {
+	if b.decodeCharset {
+		return b.Text()
+	}
+
 	if b.Err != nil {
 		return ""
 	}
@@ -75,6 +86,34 @@ func (b *ZBody) String() string {
 	return b.buf.String()
 }
 
+// Text return the body converted to UTF-8.
+// The charset is detected from BOM, the Content-Type header and the <meta> tag of html in order,
+// the body is treated as UTF-8 if it is valid UTF-8, or windows-1252 otherwise when the charset is unknown
+func (b *ZBody) Text() string {
+	if b.Err != nil {
+		return ""
+	}
+
+	b.fillBuffer()
+
+	content := b.buf.Bytes()
+	if len(content) == 0 {
+		return ""
+	}
+
+	enc, name, _ := charset.DetermineEncoding(content, b.contentType)
+	if name == "utf-8" {
+		return string(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	}
+
+	data, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return string(content)
+	}
+
+	return string(data)
+}
+
 // Bytes return the body with []byte type
 func (b *ZBody) Bytes() []byte {
 	if b.Err != nil {
@@ -202,7 +241,55 @@ type Response struct {
 	Headers       Headers
 	Body          *ZBody
 	RawResponse   *http.Response
-	cookies       Cookies
+
+	// Attempts is the number of attempts made to get this response
+	Attempts int
+
+	// Timings is the timings of the round trip which produced this response.
+	// BodyTransfer and Total are filled after the body is read to the end or closed
+	Timings *Timings
+
+	// RedirectTimings is the timings of the previous round trips when redirects were followed
+	RedirectTimings []*Timings
+
+	// ContentEncoding is the Content-Encoding of the response as received, such as gzip or "gzip, br".
+	// The body is decoded unless HTTPOptions.DisableDecompression is set or the encoding is not supported,
+	// ContentLength is -1 and the Content-Encoding and Content-Length headers are removed when the body is decoded
+	ContentEncoding string
+
+	cookies    Cookies
+	rawRequest []byte
+	compressed *countingReader
+	// the client and options which sent the request
+	z       *Zhttp
+	options *ReqOptions
+	// proxy is the proxy used by the last round trip
+	proxy *url.URL
+}
+
+// NewResponse wrap a *http.Response to *Response,
+// it is useful for the middleware which wants to return a response without sending request.
+func NewResponse(resp *http.Response) *Response {
+	body := resp.Body
+	if body == nil {
+		body = http.NoBody
+	}
+
+	return newResponse(resp, body)
+}
+
+func newResponse(resp *http.Response, body io.ReadCloser) *Response {
+	return &Response{
+		RawResponse:   resp,
+		StatusCode:    resp.StatusCode,
+		Status:        resp.Status,
+		ContentLength: resp.ContentLength,
+		Headers:       Headers(resp.Header),
+		Body: &ZBody{
+			rawBody:     body,
+			contentType: resp.Header.Get("Content-Type"),
+		},
+	}
 }
 
 // Cookies parses and returns the cookies set in the Set-Cookie headers.
@@ -224,6 +311,23 @@ func (resp *Response) Err() error {
 	return resp.Body.Err
 }
 
+// CompressedSize return the number of compressed bytes read so far,
+// it is the size of the whole compressed body after the body is read to the end.
+// It is -1 if the body is not decoded by zhttp, including the gzip decoded by go automatically
+func (resp *Response) CompressedSize() int64 {
+	if resp.compressed == nil {
+		return -1
+	}
+	return resp.compressed.count()
+}
+
+// discard read a little of the remaining body and close it,
+// so that the connection has a chance to be reused
+func (resp *Response) discard() {
+	io.CopyN(ioutil.Discard, resp.Body, 4096)
+	resp.Close()
+}
+
 // Close close the http response body.
 func (resp *Response) Close() error {
 	resp.Body.ClearCache()
@@ -233,6 +337,11 @@ func (resp *Response) Close() error {
 // DumpRequest format the last http.Request to string.
 // Notice, the order of headers is not strictly consistent
 func (resp *Response) DumpRequest() string {
+	// the request sent by Zhttp.RawRequest
+	if resp.rawRequest != nil {
+		return string(resp.rawRequest)
+	}
+
 	var buf strings.Builder
 	req := resp.RawResponse.Request.Clone(context.Background())
 
@@ -0,0 +1,260 @@
+package zhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryStatusCodes is the status codes that will be retried when RetryPolicy.StatusCodes is nil
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy describes when and how a failed request will be retried.
+// All methods will be retried, including the non-idempotent ones like POST.
+// Only the errors before the response is returned can be retried, such as dial errors,
+// connection resets and the timeout exceeded while send request, the errors occurred
+// while reading body (include the timeout exceeded while read body) will be reported by ZBody.
+// The Body that can not be reused, such as ReaderBody and MultipartStream, is read into memory
+// before the first attempt, unless SendStreamOnce is set.
+// If the request fails, the error is *RetryError with the number of attempts made
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// Zero or one means do not retry
+	MaxAttempts int
+
+	// MinBackoff is the time to wait before the first retry, it will be doubled on each retry.
+	// If zero, 100 milliseconds is used
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum amount of time to wait between two attempts,
+	// it also limits the wait time specified by Retry-After header.
+	// If zero, 30 seconds is used
+	MaxBackoff time.Duration
+
+	// DisableJitter is a flag that disables the randomization of backoff
+	DisableJitter bool
+
+	// IgnoreRetryAfter is a flag that means do not honor the Retry-After header of response
+	IgnoreRetryAfter bool
+
+	// StatusCodes is the list of status codes that will be retried.
+	// If nil, DefaultRetryStatusCodes is used
+	StatusCodes []int
+
+	// ShouldRetry allows you to decide whether to retry by yourself.
+	// It is called with the response or the error of the last attempt,
+	// and replaces the default check of status codes and errors
+	ShouldRetry func(resp *Response, err error) bool
+
+	// SendStreamOnce will send the Body that can not be reused only once without reading it into memory,
+	// the request is not retried. It is useful for the large streaming body
+	SendStreamOnce bool
+}
+
+// RetryError is returned when a request with RetryPolicy fails,
+// Err is the error of the last attempt
+type RetryError struct {
+	// Attempts is the number of attempts made
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("zhttp: request failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+func (p *RetryPolicy) enabled() bool {
+	return p != nil && p.MaxAttempts > 1
+}
+
+func (p *RetryPolicy) shouldRetry(resp *Response, err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(resp, err)
+	}
+
+	if err != nil {
+		return isRetryableError(err)
+	}
+
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff return the time to wait before the next attempt
+func (p *RetryPolicy) backoff(attempt int, resp *Response) time.Duration {
+	min := p.MinBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+
+	if resp != nil && !p.IgnoreRetryAfter {
+		if wait, ok := parseRetryAfter(resp.Headers.Get("Retry-After")); ok {
+			if wait > max {
+				wait = max
+			}
+			return wait
+		}
+	}
+
+	wait := min
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	if !p.DisableJitter {
+		half := wait / 2
+		wait = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+
+	return wait
+}
+
+// parseRetryAfter parse the Retry-After header, which may be seconds or a http date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := time.Until(t)
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait, true
+}
+
+// isRetryableError report whether the error is a temporary network error,
+// such as dial error, connection reset and timeout
+func isRetryableError(err error) bool {
+	// the caller's context is checked before, so this is a timeout
+	// triggered by HTTPOptions.Timeout or ReqOptions.Timeout
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect") {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// replayableBody make sure the body can be sent multiple times.
+// The body which can not produce the same content again will be read into memory
+func replayableBody(body Body) (Body, error) {
+	if body == nil || isReplayable(body) {
+		return body, nil
+	}
+
+	// buffer the uncompressed content, so that it is still compressed when sending
+	if cb, ok := body.(*CompressedBody); ok {
+		inner, err := replayableBody(cb.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &CompressedBody{Body: inner, Encoding: cb.Encoding}, nil
+	}
+
+	reader, contentType, err := body.Content()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if closer, ok := reader.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BytesBody{
+		ContentType: contentType,
+		Body:        data,
+	}, nil
+}
+
+// isReplayable report whether the content of body can be got more than once
+func isReplayable(body Body) bool {
+	switch b := body.(type) {
+	case *StringBody, *BytesBody, *JSONBody, *XMLBody:
+		return true
+	case *CompressedBody:
+		return isReplayable(b.Body)
+	}
+	return false
+}
+
+// sleepContext wait for the duration, or until the ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,120 @@
+package zhttp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// retryTestServer reply 503 for the first failures requests, and record the bodies received
+type retryTestServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	bodies   []string
+}
+
+func newRetryTestServer(failures int) *retryTestServer {
+	s := &retryTestServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		n := len(s.bodies)
+		s.mu.Unlock()
+
+		if n <= s.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	return s
+}
+
+func TestRetryStreamingBody(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 4, MinBackoff: 1}
+
+	tests := []struct {
+		name string
+		body func() Body
+		want string
+	}{
+		{"reader", func() Body { return Reader(strings.NewReader("data")) }, "data"},
+		{"multipart stream", func() Body {
+			return MultipartStream([]*File{{
+				Name:      "a.txt",
+				FieldName: "file",
+				Contents:  ioutil.NopCloser(strings.NewReader("file content")),
+			}}, nil)
+		}, "file content"},
+		{"compressed reader", func() Body {
+			return &CompressedBody{Body: Reader(strings.NewReader("data")), Encoding: "gzip"}
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRetryTestServer(2)
+			defer s.Close()
+
+			resp, err := New(nil).Post(s.URL, &ReqOptions{Body: tt.body(), Retry: policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Close()
+
+			if resp.StatusCode != http.StatusOK || resp.Attempts != 3 {
+				t.Errorf("got status %d after %d attempts, want 200 after 3", resp.StatusCode, resp.Attempts)
+			}
+			for i, body := range s.bodies {
+				if body == "" || body != s.bodies[0] || !strings.Contains(body, tt.want) {
+					t.Errorf("body of attempt %d is %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrySendStreamOnce(t *testing.T) {
+	s := newRetryTestServer(2)
+	defer s.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 4, MinBackoff: 1, SendStreamOnce: true}
+	resp, err := New(nil).Post(s.URL, &ReqOptions{Body: Reader(strings.NewReader("data")), Retry: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Attempts != 1 || len(s.bodies) != 1 {
+		t.Errorf("got status %d after %d attempts, want 503 after 1", resp.StatusCode, resp.Attempts)
+	}
+}
+
+func TestRetryErrorAttempts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, MinBackoff: 1}
+	_, err := New(nil).Get(ts.URL, &ReqOptions{Retry: policy})
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("got error %v, want *RetryError", err)
+	}
+	if retryErr.Attempts != 3 || retryErr.Err == nil {
+		t.Errorf("got %+v, want 3 attempts", retryErr)
+	}
+}
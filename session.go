@@ -1,44 +1,145 @@
 package zhttp
 
 import (
+	"context"
+	"errors"
 	"net/http/cookiejar"
+	"net/url"
+	"sync"
 )
 
 // Session is a client used to send http requests.
 // Unlike Zhttp, it handle session for all requests
 type Session struct {
-	z         *Zhttp
-	CookieJar *cookiejar.Jar
+	z           *Zhttp
+	middlewares []Middleware
+	CookieJar   *cookiejar.Jar
+
+	// Authenticator add the credentials to all requests of the session,
+	// overwrite HTTPOptions.Authenticator
+	Authenticator Authenticator
+
+	mu    sync.Mutex
+	proxy *pinnedProxy
+}
+
+// pinnedProxy is the proxy used by all requests of a session
+type pinnedProxy struct {
+	url  *url.URL
+	pool *ProxyPool
+}
+
+// PinProxy pick a proxy from HTTPOptions.ProxyPool, and use it for all requests of the session,
+// so that the cookies and the source ip stay consistent.
+// The proxy is still used after it is ejected from the pool, call PinProxy again to pick another one.
+// ReqOptions.Proxies and ReqOptions.ProxyPool take precedence over the pinned proxy
+func (s *Session) PinProxy() (*url.URL, error) {
+	pool := s.z.options.ProxyPool
+	if pool == nil {
+		return nil, errors.New("zhttp: HTTPOptions.ProxyPool is not set")
+	}
+
+	p, err := pool.Pick()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.proxy = &pinnedProxy{url: p, pool: pool}
+	s.mu.Unlock()
+
+	return p, nil
+}
+
+// SetProxy pin the specified proxy for all requests of the session
+func (s *Session) SetProxy(u *url.URL) {
+	s.mu.Lock()
+	s.proxy = &pinnedProxy{url: u}
+	s.mu.Unlock()
+}
+
+// UnpinProxy stop using the pinned proxy
+func (s *Session) UnpinProxy() {
+	s.mu.Lock()
+	s.proxy = nil
+	s.mu.Unlock()
+}
+
+// PinnedProxy return the proxy pinned to the session, nil if there is none
+func (s *Session) PinnedProxy() *url.URL {
+	if p := s.pinned(); p != nil {
+		return p.url
+	}
+	return nil
+}
+
+func (s *Session) pinned() *pinnedProxy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.proxy
 }
 
 func (s *Session) Get(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("GET", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "GET", url, options, s)
 }
 
 func (s *Session) Post(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("POST", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "POST", url, options, s)
 }
 
 func (s *Session) Head(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("HEAD", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "HEAD", url, options, s)
 }
 
 func (s *Session) Put(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("PUT", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "PUT", url, options, s)
 }
 
 func (s *Session) Delete(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("DELETE", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "DELETE", url, options, s)
 }
 
 func (s *Session) Patch(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("PATCH", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "PATCH", url, options, s)
 }
 
 func (s *Session) Options(url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest("OPTIONS", url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), "OPTIONS", url, options, s)
 }
 
 func (s *Session) Request(method string, url string, options *ReqOptions) (*Response, error) {
-	return s.z.doRequest(method, url, options, s.CookieJar)
+	return s.z.doRequest(context.Background(), method, url, options, s)
+}
+
+// RequestContext is like Request, but the request is bound to ctx
+func (s *Session) RequestContext(ctx context.Context, method string, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, method, url, options, s)
+}
+
+func (s *Session) GetContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "GET", url, options, s)
+}
+
+func (s *Session) PostContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "POST", url, options, s)
+}
+
+func (s *Session) HeadContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "HEAD", url, options, s)
+}
+
+func (s *Session) PutContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "PUT", url, options, s)
+}
+
+func (s *Session) DeleteContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "DELETE", url, options, s)
+}
+
+func (s *Session) PatchContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "PATCH", url, options, s)
+}
+
+func (s *Session) OptionsContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return s.z.doRequest(ctx, "OPTIONS", url, options, s)
 }
@@ -0,0 +1,198 @@
+package zhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSSigV4 create an Authenticator which signs the requests with AWS Signature Version 4
+func AWSSigV4(accessKeyID, secretAccessKey, region, service string) Authenticator {
+	return &AWSSigV4Auth{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		Service:         service,
+	}
+}
+
+// AWSSigV4Auth signs the requests with AWS Signature Version 4.
+// The body is hashed if it can be read again, otherwise UNSIGNED-PAYLOAD is used, which is accepted by S3 only
+type AWSSigV4Auth struct {
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// SessionToken is the token of temporary credentials, it is optional
+	SessionToken string
+
+	// Region is the region of the service, such as us-east-1
+	Region string
+
+	// Service is the name of the service, such as s3 and execute-api
+	Service string
+}
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func (a *AWSSigV4Auth) Authenticate(req *http.Request) error {
+	return a.sign(req, time.Now())
+}
+
+// sign add the signature of the request at time t
+func (a *AWSSigV4Auth) sign(req *http.Request, t time.Time) error {
+	t = t.UTC()
+	amzDate := t.Format(sigV4TimeFormat)
+	date := amzDate[:8]
+
+	payloadHash := emptyPayloadHash
+	body, ok, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		payloadHash = unsignedPayload
+	} else if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+	if a.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// sign host, content-type and x-amz-* headers
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		name := strings.ToLower(key)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		a.canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + a.Region + "/" + a.Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+a.SecretAccessKey), date)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, a.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+a.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	return nil
+}
+
+// canonicalURI encode each decoded segment of path, the segments are encoded twice except for s3.
+// The escaped path is split, so that the escaped slash in a segment is kept
+func (a *AWSSigV4Auth) canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		s = uriEncode(s)
+		if a.Service != "s3" {
+			s = uriEncode(s)
+		}
+		segments[i] = s
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(u *url.URL) string {
+	values, _ := url.ParseQuery(u.RawQuery)
+
+	var pairs [][2]string
+	for key, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, [2]string{uriEncode(key), uriEncode(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	list := make([]string, len(pairs))
+	for i, p := range pairs {
+		list[i] = p[0] + "=" + p[1]
+	}
+
+	return strings.Join(list, "&")
+}
+
+// uriEncode encode all characters except the unreserved characters of RFC 3986
+func uriEncode(s string) string {
+	const hexChars = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hexChars[c>>4])
+			b.WriteByte(hexChars[c&15])
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,182 @@
+package zhttp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the credentials and time of the AWS Signature Version 4 test suite
+var sigV4TestAuth = &AWSSigV4Auth{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	Region:          "us-east-1",
+	Service:         "service",
+}
+
+var sigV4TestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestAWSSigV4Sign(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "get-vanilla-query-unreserved",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signedHeaders: "host;x-amz-date",
+			signature:     "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+		{
+			// not in the suite, the signature is computed from the canonical request with the path
+			// /a%253Ab%253Dc%2540d, as the characters Go does not escape in path are encoded twice too
+			name:          "get-path-reserved",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/a:b=c@d",
+			signedHeaders: "host;x-amz-date",
+			signature:     "6512bd244d8b02c47063a834ede3fee5ed9599e7ce6202a627e12ddce4889b7a",
+		},
+		{
+			name:          "post-vanilla",
+			method:        "POST",
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        "POST",
+			url:           "https://example.amazonaws.com/",
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.body == "" {
+				req.Body, req.GetBody = http.NoBody, nil
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err = sigV4TestAuth.sign(req, sigV4TestTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" +
+				tt.signedHeaders + ", Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %s\nwant %s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+func TestAWSSigV4SignUnreadableBody(t *testing.T) {
+	req, err := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/key", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil
+
+	a := &AWSSigV4Auth{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Region: "us-east-1", Service: "s3"}
+	err = a.sign(req, sigV4TestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != unsignedPayload {
+		t.Errorf("X-Amz-Content-Sha256 = %s, want %s", got, unsignedPayload)
+	}
+}
+
+func TestCanonicalURI(t *testing.T) {
+	tests := []struct {
+		service string
+		path    string
+		rawPath string
+		want    string
+	}{
+		{"service", "", "", "/"},
+		{"service", "/", "", "/"},
+		{"service", "/example/", "", "/example/"},
+		{"service", "/-._~", "", "/-._~"},
+		// the path is encoded twice except for s3
+		{"service", "/example space/", "", "/example%2520space/"},
+		{"service", "/ሴ", "", "/%25E1%2588%25B4"},
+		{"service", "/a:b=c@d", "", "/a%253Ab%253Dc%2540d"},
+		{"service", "/!$'()*+,;", "", "/%2521%2524%2527%2528%2529%252A%252B%252C%253B"},
+		{"service", "/a/b", "/a%2Fb", "/a%252Fb"},
+		{"s3", "/example space/", "", "/example%20space/"},
+		{"s3", "/ሴ", "", "/%E1%88%B4"},
+		{"s3", "/a+b=c", "", "/a%2Bb%3Dc"},
+		{"s3", "/a/b", "/a%2Fb", "/a%2Fb"},
+	}
+
+	for _, tt := range tests {
+		a := &AWSSigV4Auth{Service: tt.service}
+		u := &url.URL{Scheme: "https", Host: "example.amazonaws.com", Path: tt.path, RawPath: tt.rawPath}
+		if got := a.canonicalURI(u); got != tt.want {
+			t.Errorf("canonicalURI(%s, %q) = %s, want %s", tt.service, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"Param1=value1", "Param1=value1"},
+		{"Param2=value2&Param1=value1", "Param1=value1&Param2=value2"},
+		{"Param1=value2&Param1=Value1", "Param1=Value1&Param1=value2"},
+		{"Param1=value1&Param1=value1", "Param1=value1&Param1=value1"},
+		{"Param1", "Param1="},
+		{"%E1%88%B4=bar", "%E1%88%B4=bar"},
+		{"a=b+c&d=e%20f", "a=b%20c&d=e%20f"},
+		{"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"},
+	}
+
+	for _, tt := range tests {
+		u := &url.URL{RawQuery: tt.query}
+		if got := canonicalQuery(u); got != tt.want {
+			t.Errorf("canonicalQuery(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
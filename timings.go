@@ -0,0 +1,216 @@
+package zhttp
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings is the time spent in each phase of a single http round trip.
+// The phase which did not happen, such as DNS lookup on reused connection, is zero.
+// The round trips of authentication challenges, such as Digest and NTLM, are counted in the same Timings,
+// the dns, tcp and tls phases are of the first connection, and the other phases are of the last round trip
+type Timings struct {
+	// Start is the time when the round trip started
+	Start time.Time
+
+	// DNSLookup is the time spent on resolving the host, including the lookup in dns cache
+	DNSLookup time.Duration
+
+	// TCPConnect is the time spent on establishing the tcp connection
+	TCPConnect time.Duration
+
+	// TLSHandshake is the time spent on tls handshake
+	TLSHandshake time.Duration
+
+	// RequestSend is the time spent on writing the request after got a connection
+	RequestSend time.Duration
+
+	// ServerProcessing is the time between the request was written and the first byte of response was received
+	ServerProcessing time.Duration
+
+	// TimeToFirstByte is the time between the start of the round trip and the first byte of response was received
+	TimeToFirstByte time.Duration
+
+	// BodyTransfer is the time spent on reading the response body.
+	// It is only set for the last round trip, after the body is read to the end or closed
+	BodyTransfer time.Duration
+
+	// Total is the time of the whole round trip.
+	// For the last round trip, it is set after the body is read to the end or closed
+	Total time.Duration
+
+	// ConnReused reports whether the connection was previously used for another request
+	ConnReused bool
+
+	// RemoteAddr is the address actually dialed, it is the address of proxy when proxy is used
+	RemoteAddr string
+}
+
+// hopTrace records the timestamps of a single round trip
+type hopTrace struct {
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+	remoteAddr   string
+}
+
+func since(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func (h *hopTrace) timings() *Timings {
+	return &Timings{
+		Start:            h.start,
+		DNSLookup:        since(h.dnsStart, h.dnsDone),
+		TCPConnect:       since(h.connectStart, h.connectDone),
+		TLSHandshake:     since(h.tlsStart, h.tlsDone),
+		RequestSend:      since(h.gotConn, h.wroteRequest),
+		ServerProcessing: since(h.wroteRequest, h.firstByte),
+		TimeToFirstByte:  since(h.start, h.firstByte),
+		ConnReused:       h.reused,
+		RemoteAddr:       h.remoteAddr,
+	}
+}
+
+var ctxTimingKey = &struct{ name string }{"timing recorder"}
+
+// timingRecorder collects the timings of all round trips of a request, including redirects
+type timingRecorder struct {
+	mu   sync.Mutex
+	hops []*hopTrace
+}
+
+func (r *timingRecorder) current() *hopTrace {
+	if len(r.hops) == 0 {
+		r.hops = append(r.hops, &hopTrace{})
+	}
+	return r.hops[len(r.hops)-1]
+}
+
+// redirect start a new hop for the redirected request
+func (r *timingRecorder) redirect() {
+	r.mu.Lock()
+	r.hops = append(r.hops, &hopTrace{})
+	r.mu.Unlock()
+}
+
+func (r *timingRecorder) record(fn func(h *hopTrace)) {
+	r.mu.Lock()
+	fn(r.current())
+	r.mu.Unlock()
+}
+
+func (r *timingRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			r.record(func(h *hopTrace) {
+				if h.start.IsZero() {
+					h.start = time.Now()
+				}
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.record(func(h *hopTrace) {
+				if h.dnsStart.IsZero() {
+					h.dnsStart = time.Now()
+				}
+			})
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.record(func(h *hopTrace) {
+				if h.dnsDone.IsZero() {
+					h.dnsDone = time.Now()
+				}
+			})
+		},
+		ConnectStart: func(network, addr string) {
+			r.record(func(h *hopTrace) {
+				if h.connectStart.IsZero() {
+					h.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				r.record(func(h *hopTrace) {
+					if h.connectDone.IsZero() {
+						h.connectDone = time.Now()
+					}
+				})
+			}
+		},
+		TLSHandshakeStart: func() {
+			r.record(func(h *hopTrace) {
+				if h.tlsStart.IsZero() {
+					h.tlsStart = time.Now()
+				}
+			})
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.record(func(h *hopTrace) {
+				if h.tlsDone.IsZero() {
+					h.tlsDone = time.Now()
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.record(func(h *hopTrace) {
+				h.gotConn = time.Now()
+				h.reused = info.Reused
+				if info.Conn != nil {
+					h.remoteAddr = info.Conn.RemoteAddr().String()
+				}
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			r.record(func(h *hopTrace) { h.wroteRequest = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			r.record(func(h *hopTrace) { h.firstByte = time.Now() })
+		},
+	}
+}
+
+// timings return the timings of all round trips, the last one is the final response
+func (r *timingRecorder) timings() []*Timings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]*Timings, len(r.hops))
+	for i, h := range r.hops {
+		list[i] = h.timings()
+		if i < len(r.hops)-1 {
+			list[i].Total = since(h.start, r.hops[i+1].start)
+		}
+	}
+
+	return list
+}
+
+// bodyDone fill the body transfer time and total time of the last round trip
+func (r *timingRecorder) bodyDone(t *Timings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.hops) == 0 {
+		return
+	}
+
+	now := time.Now()
+	h := r.hops[len(r.hops)-1]
+	t.BodyTransfer = since(h.firstByte, now)
+	t.Total = since(h.start, now)
+}
@@ -0,0 +1,58 @@
+package zhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTimingsHops(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/auth", http.StatusFound)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Digest ") {
+			// the challenge round trip gets a new connection
+			w.Header().Set("Connection", "close")
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	z := New(&HTTPOptions{Authenticator: Digest("user", "pass")})
+
+	tests := []struct {
+		path      string
+		redirects int
+	}{
+		{"/auth", 0},
+		{"/redirect", 1},
+	}
+
+	for _, tt := range tests {
+		resp, err := z.Get(ts.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, want 200", tt.path, resp.StatusCode)
+		}
+		if len(resp.RedirectTimings) != tt.redirects {
+			t.Errorf("%s: got %d redirect timings, want %d", tt.path, len(resp.RedirectTimings), tt.redirects)
+		}
+		if resp.Timings == nil || resp.Timings.Start.IsZero() || resp.Timings.TimeToFirstByte <= 0 {
+			t.Errorf("%s: invalid timings %+v", tt.path, resp.Timings)
+		}
+		for _, timings := range resp.RedirectTimings {
+			if timings.Start.IsZero() || timings.Total <= 0 {
+				t.Errorf("%s: invalid redirect timings %+v", tt.path, timings)
+			}
+		}
+	}
+}
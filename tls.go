@@ -0,0 +1,174 @@
+package zhttp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// newTLSConfig build the tls config by the options, nil is returned if there is nothing to set.
+// The pin verifier is returned if HTTPOptions.Pins is set
+func newTLSConfig(options *HTTPOptions) (*tls.Config, *pinVerifier, error) {
+	if !options.InsecureSkipVerify && options.ClientCertFile == "" && len(options.ClientCertificates) == 0 &&
+		options.RootCAs == nil && len(options.RootCAFiles) == 0 && options.TLSMinVersion == 0 &&
+		options.TLSMaxVersion == 0 && len(options.CipherSuites) == 0 && len(options.CurvePreferences) == 0 &&
+		len(options.NextProtos) == 0 && len(options.Pins) == 0 {
+		return nil, nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		MinVersion:         options.TLSMinVersion,
+		MaxVersion:         options.TLSMaxVersion,
+		CipherSuites:       options.CipherSuites,
+		CurvePreferences:   options.CurvePreferences,
+		NextProtos:         options.NextProtos,
+	}
+
+	config.Certificates = append(config.Certificates, options.ClientCertificates...)
+	if options.ClientCertFile != "" {
+		keyFile := options.ClientKeyFile
+		if keyFile == "" {
+			// the key is in the same file with the certificate
+			keyFile = options.ClientCertFile
+		}
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zhttp: load client certificate failed: %w", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if options.RootCAs != nil || len(options.RootCAFiles) > 0 {
+		// the certificates of RootCAFiles are added to RootCAs, SystemCertPool returns a new copy of system roots
+		pool := options.RootCAs
+		if pool == nil {
+			var err error
+			pool, err = x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+
+		for _, file := range options.RootCAFiles {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, nil, fmt.Errorf("zhttp: load root CA failed: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, nil, fmt.Errorf("zhttp: no certificate found in root CA file %s", file)
+			}
+		}
+
+		config.RootCAs = pool
+	}
+
+	var verifier *pinVerifier
+	if len(options.Pins) > 0 {
+		var err error
+		verifier, err = newPinVerifier(options.Pins)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.VerifyConnection = verifier.verifyConnection
+	}
+
+	return config, verifier, nil
+}
+
+// setTLSConfig set the tls config of transport.
+// HTTP/2 is disabled if the ALPN protocols are specified without h2,
+// because go always adds h2 to the protocols when HTTP/2 is enabled
+func setTLSConfig(transport *http.Transport, config *tls.Config) {
+	if config == nil {
+		return
+	}
+
+	transport.TLSClientConfig = config
+
+	if len(config.NextProtos) == 0 {
+		return
+	}
+	if containsString(config.NextProtos, "h2") {
+		// the transport may be derived from the one whose HTTP/2 is disabled
+		transport.ForceAttemptHTTP2 = true
+		transport.TLSNextProto = nil
+	} else {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+}
+
+// tlsKey identify the tls settings of ReqOptions
+type tlsKey struct {
+	serverName string
+	insecure   bool
+
+	// certs is the SHA-256 of the client certificates
+	certs string
+
+	nextProtos string
+}
+
+func newTLSKey(options *ReqOptions) tlsKey {
+	key := tlsKey{
+		serverName: options.ServerName,
+		insecure:   options.InsecureSkipVerify,
+		nextProtos: strings.Join(options.NextProtos, "\x00"),
+	}
+
+	if len(options.ClientCertificates) > 0 {
+		h := sha256.New()
+		for _, cert := range options.ClientCertificates {
+			for _, der := range cert.Certificate {
+				h.Write(der)
+			}
+			h.Write([]byte{0})
+		}
+		key.certs = string(h.Sum(nil))
+	}
+
+	return key
+}
+
+// requestTLSConfig return the copy of base with the tls settings of ReqOptions, base and options can be nil
+func requestTLSConfig(base *tls.Config, options *ReqOptions) *tls.Config {
+	var config *tls.Config
+	if base != nil {
+		config = base.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if options == nil {
+		return config
+	}
+
+	if options.ServerName != "" {
+		config.ServerName = options.ServerName
+	}
+	if options.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+	}
+	if len(options.ClientCertificates) > 0 {
+		config.Certificates = options.ClientCertificates
+	}
+	if len(options.NextProtos) > 0 {
+		config.NextProtos = options.NextProtos
+	}
+
+	return config
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
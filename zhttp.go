@@ -1,9 +1,13 @@
 package zhttp
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/greyh4t/dnscache"
@@ -11,37 +15,70 @@ import (
 )
 
 type Zhttp struct {
-	options   *HTTPOptions
-	dnsCache  *dnscache.Cache
-	transport *http.Transport
+	options      *HTTPOptions
+	dnsCache     *dnscache.Cache
+	resolver     Resolver
+	dialer       *net.Dialer
+	transport    *http.Transport
+	roundTripper *roundTripper
+	limiter      *rateLimiter
+	pins         *pinVerifier
+	middlewares  []Middleware
+
+	// ownDNSCache is true if dnsCache is created by Zhttp, which is closed with Zhttp
+	ownDNSCache bool
+
+	// initErr is the error of options found when creating the client, it is returned by all requests
+	initErr error
+
+	// mu guards the state of closing and the requests in flight
+	mu       sync.Mutex
+	closed   bool
+	inflight int
+	// idle is closed when Zhttp is closed and no request is in flight
+	idle chan struct{}
 }
 
-// New generate an *Zhttp client to send request
+// New generate an *Zhttp client to send request.
+// If the options can not be applied, such as ClientCertFile or RootCAFiles can not be loaded,
+// the client is still returned, and the error is returned by all its requests
 func New(options *HTTPOptions) *Zhttp {
 	z := &Zhttp{options: options}
 	if z.options == nil {
 		z.options = &HTTPOptions{}
 	}
 
-	var cache *dnscache.Cache
-	if z.options.DNSCacheExpire > 0 {
+	if z.options.Resolver != nil {
+		z.resolver = z.options.Resolver
+	} else if z.options.DNSCacheExpire > 0 {
 		if z.options.DNSServer != "" {
-			cache = dnscache.NewWithServer(z.options.DNSCacheExpire, z.options.DNSServer)
+			z.dnsCache = dnscache.NewWithServer(z.options.DNSCacheExpire, z.options.DNSServer)
 		} else {
-			cache = dnscache.New(z.options.DNSCacheExpire)
+			z.dnsCache = dnscache.New(z.options.DNSCacheExpire)
 		}
-		z.dnsCache = cache
+		z.ownDNSCache = true
+		z.resolver = &CacheResolver{Cache: z.dnsCache}
+	} else if z.options.DNSServer != "" {
+		z.resolver = &SystemResolver{Server: z.options.DNSServer}
 	}
 
-	z.transport = createTransport(z.options, cache)
+	var tlsConfig *tls.Config
+	tlsConfig, z.pins, z.initErr = newTLSConfig(z.options)
+
+	z.dialer = newDialer(z.options)
+	z.transport = createTransport(z.options, tlsConfig, z.dialer, z.resolver)
+	z.roundTripper = newRoundTripper(z)
+	z.limiter = newRateLimiter(z.options.RateLimit)
 
-	ensureResourcesFinalized(z, z.dnsCache != nil)
+	ensureResourcesFinalized(z)
 
 	return z
 }
 
 // NewWithDNSCache generate an *Zhttp client that uses an external DNSCache.
-// This will ignore HTTPOptions.DNSCacheExpire and HTTPOptions.DNSServer
+// This will ignore HTTPOptions.DNSCacheExpire and HTTPOptions.DNSServer,
+// and the cache is not used if HTTPOptions.Resolver is set.
+// The error of options is returned by all requests like New
 func NewWithDNSCache(options *HTTPOptions, cache *dnscache.Cache) *Zhttp {
 	z := &Zhttp{options: options}
 	if z.options == nil {
@@ -51,21 +88,35 @@ func NewWithDNSCache(options *HTTPOptions, cache *dnscache.Cache) *Zhttp {
 	if cache != nil {
 		z.dnsCache = cache
 	}
+	if z.options.Resolver != nil {
+		z.resolver = z.options.Resolver
+	} else if cache != nil {
+		z.resolver = &CacheResolver{Cache: cache}
+	}
 
-	z.transport = createTransport(z.options, cache)
+	var tlsConfig *tls.Config
+	tlsConfig, z.pins, z.initErr = newTLSConfig(z.options)
 
-	ensureResourcesFinalized(z, false)
+	z.dialer = newDialer(z.options)
+	z.transport = createTransport(z.options, tlsConfig, z.dialer, z.resolver)
+	z.roundTripper = newRoundTripper(z)
+	z.limiter = newRateLimiter(z.options.RateLimit)
+
+	ensureResourcesFinalized(z)
 
 	return z
 }
 
-func ensureResourcesFinalized(zhttp *Zhttp, finalizeDNSCache bool) {
-	runtime.SetFinalizer(zhttp, func(z *Zhttp) {
-		z.transport.CloseIdleConnections()
-		if finalizeDNSCache {
-			z.dnsCache.Close()
-		}
-	})
+func ensureResourcesFinalized(zhttp *Zhttp) {
+	runtime.SetFinalizer(zhttp, (*Zhttp).releaseResources)
+}
+
+// releaseResources close the idle connections and the dns cache created by Zhttp
+func (z *Zhttp) releaseResources() {
+	z.roundTripper.CloseIdleConnections()
+	if z.ownDNSCache {
+		z.dnsCache.Close()
+	}
 }
 
 // NewSession generate an client that will handle session for all requests
@@ -76,35 +127,69 @@ func (z *Zhttp) NewSession() *Session {
 }
 
 func (z *Zhttp) Request(method, url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest(method, url, options, nil)
+	return z.doRequest(context.Background(), method, url, options, nil)
 }
 
 func (z *Zhttp) Get(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("GET", url, options, nil)
+	return z.doRequest(context.Background(), "GET", url, options, nil)
 }
 
 func (z *Zhttp) Delete(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("DELETE", url, options, nil)
+	return z.doRequest(context.Background(), "DELETE", url, options, nil)
 }
 
 func (z *Zhttp) Head(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("HEAD", url, options, nil)
+	return z.doRequest(context.Background(), "HEAD", url, options, nil)
 }
 
 func (z *Zhttp) Patch(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("PATCH", url, options, nil)
+	return z.doRequest(context.Background(), "PATCH", url, options, nil)
 }
 
 func (z *Zhttp) Post(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("POST", url, options, nil)
+	return z.doRequest(context.Background(), "POST", url, options, nil)
 }
 
 func (z *Zhttp) Put(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("PUT", url, options, nil)
+	return z.doRequest(context.Background(), "PUT", url, options, nil)
 }
 
 func (z *Zhttp) Options(url string, options *ReqOptions) (*Response, error) {
-	return z.doRequest("OPTIONS", url, options, nil)
+	return z.doRequest(context.Background(), "OPTIONS", url, options, nil)
+}
+
+// RequestContext is like Request, but the request is bound to ctx.
+// When ctx is canceled or its deadline is exceeded, the request and the reading of body will be aborted
+func (z *Zhttp) RequestContext(ctx context.Context, method, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, method, url, options, nil)
+}
+
+func (z *Zhttp) GetContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "GET", url, options, nil)
+}
+
+func (z *Zhttp) DeleteContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "DELETE", url, options, nil)
+}
+
+func (z *Zhttp) HeadContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "HEAD", url, options, nil)
+}
+
+func (z *Zhttp) PatchContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "PATCH", url, options, nil)
+}
+
+func (z *Zhttp) PostContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "POST", url, options, nil)
+}
+
+func (z *Zhttp) PutContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "PUT", url, options, nil)
+}
+
+func (z *Zhttp) OptionsContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	return z.doRequest(ctx, "OPTIONS", url, options, nil)
 }
 
 var defaultZ *Zhttp
@@ -130,40 +215,81 @@ func NewSession() *Session {
 
 func Request(method, url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest(method, url, options, nil)
+	return defaultZ.doRequest(context.Background(), method, url, options, nil)
 }
 
 func Get(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("GET", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "GET", url, options, nil)
 }
 
 func Delete(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("DELETE", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "DELETE", url, options, nil)
 }
 
 func Head(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("HEAD", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "HEAD", url, options, nil)
 }
 
 func Patch(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("PATCH", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "PATCH", url, options, nil)
 }
 
 func Post(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("POST", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "POST", url, options, nil)
 }
 
 func Put(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("PUT", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "PUT", url, options, nil)
 }
 
 func Options(url string, options *ReqOptions) (*Response, error) {
 	prepareDefaultZ()
-	return defaultZ.doRequest("OPTIONS", url, options, nil)
+	return defaultZ.doRequest(context.Background(), "OPTIONS", url, options, nil)
+}
+
+// RequestContext send request with the default client, the request is bound to ctx
+func RequestContext(ctx context.Context, method, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, method, url, options, nil)
+}
+
+func GetContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "GET", url, options, nil)
+}
+
+func DeleteContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "DELETE", url, options, nil)
+}
+
+func HeadContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "HEAD", url, options, nil)
+}
+
+func PatchContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "PATCH", url, options, nil)
+}
+
+func PostContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "POST", url, options, nil)
+}
+
+func PutContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "PUT", url, options, nil)
+}
+
+func OptionsContext(ctx context.Context, url string, options *ReqOptions) (*Response, error) {
+	prepareDefaultZ()
+	return defaultZ.doRequest(ctx, "OPTIONS", url, options, nil)
 }